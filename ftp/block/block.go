@@ -1,107 +1,458 @@
+// Package block implements the block transmission mode.
+//
+// By default the blocks are framed as in RFC 959, each block is a header of
+// a descriptor byte and a 16-bit byte count, followed by the data:
+//
+//	+------------+---------------+----------------+
+//	| descriptor | count (16bit) | count bytes    |
+//	+------------+---------------+----------------+
+//
+// The checksummed framing is an extension of our own, which has to be
+// negotiated with the peer. See Config.
 package block
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"ftp/record"
 	"hash"
-	"hash/fnv"
 	"io"
+	"strconv"
 )
 
-var _BlockSize int64 = 1 << 10
+const (
+	DescriptorEOR     byte = 0x80 // end of record
+	DescriptorEOF     byte = 0x40 // end of file
+	DescriptorSuspect byte = 0x20 // suspected errors in data
+	DescriptorRestart byte = 0x10 // data is a restart marker
 
-func SetBlockSize(size int64) {
-	_BlockSize = size
+	// The byte count of an RFC 959 header is 16 bits.
+	maxStandardBlockSize = 1<<16 - 1
+
+	DefaultBlockSize int64 = 1 << 10
+	// The receiver allocates a block of the size in the header, which is
+	// limited so that a broken header does not exhaust the memory.
+	MaxBlockSize int64 = 16 << 20
+)
+
+// The framing of the blocks, which the sender and the receiver must agree on.
+// The zero value is the RFC 959 framing.
+type Config struct {
+	// In the checksummed framing, each file starts with a header of the
+	// block size and the checksum algorithm. Each block has the fixed size
+	// and is followed by a footer of the data length, a checksum of the
+	// block and the descriptor.
+	Checksum bool
+	// The algorithm the sender checksums the blocks with, FNV by default.
+	// The receiver uses the one in the header.
+	Hash Hash
+	// Bytes of data in a block sent, DefaultBlockSize if 0. The RFC 959
+	// framing sends at most 65535 bytes a block. In the checksummed framing
+	// the receiver uses the size in the header.
+	BlockSize int64
+
+	// Bytes of data between two restart markers sent, 0 sends none. The
+	// marker is the offset of the data following it in decimal, counted
+	// from RestartOffset, so it can be used in a REST command.
+	RestartInterval int64
+	RestartOffset   int64
+	// Called by the receiver with each restart marker, after all the data
+	// before it has been written.
+	OnRestart func(marker string)
+
+	// In the checksummed framing, a damaged block is written as zeros and
+	// the transfer goes on, so that the block can be resent afterwards. See
+	// DamagedError. No restart markers are sent, as a damaged block can not
+	// be told from a marker.
+	Repair bool
+}
+
+// Returned by Receive when the transfer fails after a restart marker, the
+// data up to the marker has been written.
+type RestartError struct {
+	Marker string
+	Err    error
+}
+
+func (e *RestartError) Error() string {
+	return fmt.Sprintf("%v, restart at %s", e.Err, e.Marker)
+}
+
+func (e *RestartError) Unwrap() error {
+	return e.Err
 }
 
 // In block mode, each file is divided into blocks, and each block has a fixed size.
 // The conn is shared by all the files, the header is to spilt the file.
 type _BlockHdr struct {
 	BlockSize int64
+	Hash      Hash
 }
 
-type _BlockFtr struct {
-	Length   int64
-	Checksum uint64
-	EOF      bool
+// The footer is the data length, the checksum of the size of the algorithm,
+// and the descriptor.
+func footerSize(hasher hash.Hash) int {
+	return 8 + hasher.Size() + 1
 }
 
-var HashAlgorithm = func() hash.Hash64 { return fnv.New64() }
-var ErrBrokenBlock = errors.New("block broken")
+// Header of a block in the RFC 959 framing.
+type _StandardHdr struct {
+	Descriptor byte
+	Count      uint16
+}
+
+var (
+	ErrBrokenBlock = errors.New("block broken")
+	ErrBlockSize   = errors.New("block size out of range")
+)
+
+// Sends the file in the RFC 959 framing.
+func Send(dst io.Writer, src io.Reader) error {
+	return Config{}.Send(dst, src)
+}
 
-//Given a stream of data, pack it into one block, and write it to the writer w.
-func Send(dst io.Writer, src io.Reader) (err error) {
-	binary.Write(dst, binary.BigEndian, _BlockHdr{_BlockSize})
+func Receive(dst io.Writer, src io.Reader) error {
+	return Config{}.Receive(dst, src)
+}
 
-	r := io.TeeReader(src, dst)
+func SendRecords(dst io.Writer, src record.Reader) error {
+	return Config{}.SendRecords(dst, src)
+}
 
-	blockFtr := _BlockFtr{EOF: false}
+func ReceiveRecords(dst record.Writer, src io.Reader) error {
+	return Config{}.ReceiveRecords(dst, src)
+}
 
-	hasher := HashAlgorithm()
+// Given a stream of data, pack it into blocks, and write them to the writer dst.
+func (config Config) Send(dst io.Writer, src io.Reader) (err error) {
+	w := config.newWriter(dst)
+	if _, err := io.Copy(w, src); err != nil {
+		return err
+	}
+	return w.end(DescriptorEOF)
+}
 
+// Sends each record ending with a block of the EOR descriptor, and an empty
+// block of the EOF descriptor after the last one. A record can not be resumed
+// at a byte offset, so no restart markers are sent.
+func (config Config) SendRecords(dst io.Writer, src record.Reader) error {
+	config.RestartInterval = 0
+	w := config.newWriter(dst)
 	for {
-		hasher.Reset()
-		if blockFtr.Length, err = io.CopyN(hasher, r, _BlockSize); err != nil {
-			if err == io.EOF {
-				break
-			}
+		rec, err := src.ReadRecord()
+		if err == io.EOF {
+			break
+		} else if err != nil {
 			return err
 		}
-		blockFtr.Checksum = hasher.Sum64()
 
-		binary.Write(dst, binary.BigEndian, blockFtr)
+		if _, err := w.Write(rec); err != nil {
+			return err
+		}
+		if err := w.end(DescriptorEOR); err != nil {
+			return err
+		}
 	}
+	return w.end(DescriptorEOF)
+}
 
-	padding := make([]byte, _BlockSize-blockFtr.Length)
-	if _, err = dst.Write(padding); err != nil {
-		return err
+// Buffers the data into blocks, each followed by its footer.
+type writer struct {
+	dst    io.Writer
+	config Config
+	block  []byte
+	n      int
+	hasher hash.Hash
+	footer []byte
+	err    error
+
+	sent        int64 // offset of the data, as in the restart markers
+	nextRestart int64
+}
+
+func (config Config) newWriter(dst io.Writer) *writer {
+	if config.Checksum && config.Repair {
+		config.RestartInterval = 0
 	}
-	if _, err = hasher.Write(padding); err != nil {
-		return err
+	w := &writer{
+		dst:         dst,
+		config:      config,
+		sent:        config.RestartOffset,
+		nextRestart: config.RestartOffset + config.RestartInterval,
 	}
-	blockFtr.Checksum = hasher.Sum64()
-	blockFtr.EOF = true
-	return binary.Write(dst, binary.BigEndian, blockFtr)
+	size := config.blockSize()
+	if size <= 0 || size > MaxBlockSize {
+		w.err = ErrBlockSize
+		return w
+	}
+	if config.Checksum {
+		w.block = make([]byte, size)
+		if w.hasher, w.err = config.Hash.new(); w.err != nil {
+			return w
+		}
+		w.footer = make([]byte, 0, footerSize(w.hasher))
+		w.err = binary.Write(dst, binary.BigEndian, _BlockHdr{size, config.Hash})
+	} else {
+		if size > maxStandardBlockSize {
+			size = maxStandardBlockSize
+		}
+		w.block = make([]byte, size)
+	}
+	return w
 }
 
-func Receive(dst io.Writer, src io.Reader) error {
+func (config Config) blockSize() int64 {
+	if config.BlockSize == 0 {
+		return DefaultBlockSize
+	}
+	return config.BlockSize
+}
 
-	var blockHdr _BlockHdr
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 && w.err == nil {
+		if w.n == len(w.block) {
+			w.flush(0)
+		}
+		room := w.block[w.n:]
+		if w.config.RestartInterval > 0 && int64(len(room)) > w.nextRestart-w.sent {
+			room = room[:w.nextRestart-w.sent]
+		}
+		n := copy(room, p)
+		w.n += n
+		w.sent += int64(n)
+		written += n
+		p = p[n:]
 
-	binary.Read(src, binary.BigEndian, &blockHdr)
+		if w.config.RestartInterval > 0 && w.sent == w.nextRestart {
+			w.restart()
+			w.nextRestart += w.config.RestartInterval
+		}
+	}
+	return written, w.err
+}
 
-	block := make([]byte, blockHdr.BlockSize)
+// Sends the buffered data, and a restart marker of the offset after it. A
+// marker longer than the block is not sent.
+func (w *writer) restart() {
+	w.flush(0)
+	marker := strconv.FormatInt(w.sent, 10)
+	if len(marker) <= len(w.block) {
+		w.n = copy(w.block, marker)
+		w.flush(DescriptorRestart)
+	}
+}
 
-	hasher := HashAlgorithm()
+// Sends the buffered data, which may be empty, in a block of the descriptor.
+func (w *writer) end(descriptor byte) error {
+	w.flush(descriptor)
+	return w.err
+}
 
-	r := io.TeeReader(src, hasher)
+func (w *writer) flush(descriptor byte) {
+	if w.err != nil {
+		return
+	}
+	if w.config.Checksum {
+		w.flushChecksum(descriptor)
+	} else {
+		w.flushStandard(descriptor)
+	}
+	w.n = 0
+}
 
-	var blockFtr _BlockFtr
-	for {
-		hasher.Reset()
+func (w *writer) flushStandard(descriptor byte) {
+	if w.err = binary.Write(w.dst, binary.BigEndian, _StandardHdr{descriptor, uint16(w.n)}); w.err != nil {
+		return
+	}
+	_, w.err = w.dst.Write(w.block[:w.n])
+}
 
-		if _, err := io.ReadFull(r, block); err != nil {
-			return ErrBrokenBlock
-		}
+// The unused part of the block is padded with zeros.
+func (w *writer) flushChecksum(descriptor byte) {
+	for i := w.n; i < len(w.block); i++ {
+		w.block[i] = 0
+	}
 
-		checksum := hasher.Sum64()
+	w.hasher.Reset()
+	w.hasher.Write(w.block)
+	footer := w.footer[:8]
+	binary.BigEndian.PutUint64(footer, uint64(w.n))
+	footer = append(w.hasher.Sum(footer), descriptor)
 
-		if err := binary.Read(src, binary.BigEndian, &blockFtr); err != nil {
-			return ErrBrokenBlock
+	if _, w.err = w.dst.Write(w.block); w.err != nil {
+		return
+	}
+	_, w.err = w.dst.Write(footer)
+}
+
+func (config Config) Receive(dst io.Writer, src io.Reader) error {
+	r, err := config.newReader(src)
+	if err != nil {
+		return err
+	}
+
+	var marker string
+	fail := func(err error) error {
+		if marker == "" {
+			return err
 		}
+		return &RestartError{Marker: marker, Err: err}
+	}
 
-		if blockFtr.Checksum != checksum {
-			return ErrBrokenBlock
+	for {
+		data, descriptor, err := r.next()
+		if err != nil {
+			return fail(err)
 		}
 
-		if n, err := dst.Write(block[:blockFtr.Length]); err != nil || int64(n) != blockFtr.Length {
-			return err
+		if descriptor&DescriptorRestart != 0 {
+			marker = string(data)
+			if config.OnRestart != nil {
+				config.OnRestart(marker)
+			}
+			continue
 		}
 
-		if blockFtr.EOF {
+		if _, err := dst.Write(data); err != nil {
+			return fail(err)
+		}
+
+		if descriptor&DescriptorEOF != 0 {
 			break
 		}
 	}
 
+	if len(r.damaged) > 0 {
+		return &DamagedError{Indexes: r.damaged, Start: config.RestartOffset, BlockSize: int64(len(r.block))}
+	}
 	return nil
 }
+
+// Receives the records sent by SendRecords. Data after the last end of
+// record is a record as well.
+func (config Config) ReceiveRecords(dst record.Writer, src io.Reader) error {
+	config.Repair = false
+	r, err := config.newReader(src)
+	if err != nil {
+		return err
+	}
+
+	var rec []byte
+	for {
+		data, descriptor, err := r.next()
+		if err != nil {
+			return err
+		}
+		if descriptor&DescriptorRestart != 0 {
+			continue
+		}
+		rec = append(rec, data...)
+
+		if descriptor&DescriptorEOR != 0 || (descriptor&DescriptorEOF != 0 && len(rec) > 0) {
+			if err := dst.WriteRecord(rec); err != nil {
+				return err
+			}
+			rec = nil
+		}
+		if descriptor&DescriptorEOF != 0 {
+			return nil
+		}
+	}
+}
+
+type reader struct {
+	src    io.Reader
+	config Config
+	block  []byte
+	hasher hash.Hash
+	footer []byte
+	sum    []byte
+
+	index   int64 // of the next data block
+	damaged []int64
+}
+
+func (config Config) newReader(src io.Reader) (*reader, error) {
+	if !config.Checksum {
+		return &reader{src: src, config: config, block: make([]byte, maxStandardBlockSize)}, nil
+	}
+
+	var blockHdr _BlockHdr
+	if err := binary.Read(src, binary.BigEndian, &blockHdr); err != nil {
+		return nil, ErrBrokenBlock
+	}
+	if blockHdr.BlockSize <= 0 || blockHdr.BlockSize > MaxBlockSize {
+		return nil, ErrBlockSize
+	}
+	hasher, err := blockHdr.Hash.new()
+	if err != nil {
+		return nil, err
+	}
+
+	return &reader{
+		src:    src,
+		config: config,
+		block:  make([]byte, blockHdr.BlockSize),
+		hasher: hasher,
+		footer: make([]byte, footerSize(hasher)),
+	}, nil
+}
+
+// Reads the next block, the returned data is valid until the next call.
+func (r *reader) next() ([]byte, byte, error) {
+	if r.config.Checksum {
+		return r.nextChecksum()
+	}
+
+	var hdr _StandardHdr
+	if err := binary.Read(r.src, binary.BigEndian, &hdr); err != nil {
+		return nil, 0, ErrBrokenBlock
+	}
+	data := r.block[:hdr.Count]
+	if _, err := io.ReadFull(r.src, data); err != nil {
+		return nil, 0, ErrBrokenBlock
+	}
+
+	return data, hdr.Descriptor, nil
+}
+
+func (r *reader) nextChecksum() ([]byte, byte, error) {
+	if _, err := io.ReadFull(r.src, r.block); err != nil {
+		return nil, 0, ErrBrokenBlock
+	}
+
+	if _, err := io.ReadFull(r.src, r.footer); err != nil {
+		return nil, 0, ErrBrokenBlock
+	}
+	length := int64(binary.BigEndian.Uint64(r.footer))
+	checksum := r.footer[8 : len(r.footer)-1]
+	descriptor := r.footer[len(r.footer)-1]
+
+	if length < 0 || length > int64(len(r.block)) {
+		return nil, 0, ErrBrokenBlock
+	}
+	data := r.block[:length]
+
+	r.hasher.Reset()
+	r.hasher.Write(r.block)
+	r.sum = r.hasher.Sum(r.sum[:0])
+	if !bytes.Equal(r.sum, checksum) {
+		// The footer is trusted for the length and the end of file, which
+		// is how far an isolated error in the data can be repaired.
+		if !r.config.Repair {
+			return nil, 0, ErrBrokenBlock
+		}
+		for i := range data {
+			data[i] = 0
+		}
+		r.damaged = append(r.damaged, r.index)
+	}
+	if descriptor&DescriptorRestart == 0 {
+		r.index++
+	}
+
+	return data, descriptor, nil
+}
@@ -1,9 +1,15 @@
 package block
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
 	"net"
 	"os"
 	"path"
+	"strings"
 	"testing"
 )
 
@@ -79,3 +85,250 @@ func BenchmarkBlock(b *testing.B) {
 		}
 	}
 }
+
+type records [][]byte
+
+func (r *records) ReadRecord() ([]byte, error) {
+	if len(*r) == 0 {
+		return nil, io.EOF
+	}
+	rec := (*r)[0]
+	*r = (*r)[1:]
+	return rec, nil
+}
+
+func (r *records) WriteRecord(record []byte) error {
+	*r = append(*r, record)
+	return nil
+}
+
+func TestStandard(t *testing.T) {
+	config := Config{BlockSize: 4}
+	var conn bytes.Buffer
+	config.Send(&conn, bytes.NewBufferString("abcdef"))
+	config.SendRecords(&conn, &records{[]byte("ab")})
+
+	expect := []byte{
+		0x00, 0, 4, 'a', 'b', 'c', 'd',
+		DescriptorEOF, 0, 2, 'e', 'f',
+		DescriptorEOR, 0, 2, 'a', 'b',
+		DescriptorEOF, 0, 0,
+	}
+	if !bytes.Equal(conn.Bytes(), expect) {
+		t.Fatalf("got % x\nwant % x", conn.Bytes(), expect)
+	}
+
+	// Restart markers are not data.
+	conn.Reset()
+	conn.Write([]byte{0x00, 0, 1, 'a', DescriptorRestart, 0, 2, '1', '0', DescriptorEOF, 0, 1, 'b'})
+	var file bytes.Buffer
+	if err := Receive(&file, &conn); err != nil || file.String() != "ab" {
+		t.Fatalf("got %q, %v", file.String(), err)
+	}
+}
+
+func TestRecords(t *testing.T) {
+	for _, config := range []Config{{BlockSize: 4}, {BlockSize: 4, Checksum: true}} {
+		// Records shorter, equal to and longer than a block.
+		sent := records{[]byte("ab"), {}, []byte("abcd"), []byte("abcdefghij")}
+
+		var conn bytes.Buffer
+		if err := config.SendRecords(&conn, &records{sent[0], sent[1], sent[2], sent[3]}); err != nil {
+			t.Fatal(err)
+		}
+		// A file can follow on the same connection.
+		config.Send(&conn, bytes.NewBufferString("file"))
+
+		var received records
+		if err := config.ReceiveRecords(&received, &conn); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprintf("%q", received) != fmt.Sprintf("%q", sent) {
+			t.Fatalf("%+v: got %q, want %q", config, received, sent)
+		}
+
+		var file bytes.Buffer
+		if err := config.Receive(&file, &conn); err != nil || file.String() != "file" {
+			t.Fatalf("%+v: got %q, %v", config, file.String(), err)
+		}
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	var conn bytes.Buffer
+	config := Config{Checksum: true}
+	config.Send(&conn, bytes.NewBufferString("data"))
+
+	// A flipped bit in the data is found by the checksum.
+	data := conn.Bytes()
+	data[9] ^= 1
+	if err := config.Receive(&bytes.Buffer{}, &conn); err != ErrBrokenBlock {
+		t.Fatal("corrupted block should be broken")
+	}
+}
+
+func TestBlockSize(t *testing.T) {
+	// The receiver follows the size in the header.
+	var conn bytes.Buffer
+	Config{Checksum: true, BlockSize: 3}.Send(&conn, bytes.NewBufferString("abcdefg"))
+	if conn.Len() != 9+3*(3+17) {
+		t.Fatalf("sent %d bytes", conn.Len())
+	}
+	var file bytes.Buffer
+	if err := (Config{Checksum: true}).Receive(&file, &conn); err != nil || file.String() != "abcdefg" {
+		t.Fatalf("got %q, %v", file.String(), err)
+	}
+
+	// The RFC 959 framing sends at most 65535 bytes a block.
+	conn.Reset()
+	Config{BlockSize: 1 << 17}.Send(&conn, bytes.NewReader(make([]byte, 1<<16)))
+	if conn.Len() != 3+maxStandardBlockSize+3+1 {
+		t.Fatalf("sent %d bytes", conn.Len())
+	}
+
+	for _, size := range []int64{-1, MaxBlockSize + 1} {
+		if err := (Config{Checksum: true, BlockSize: size}).Send(&bytes.Buffer{}, bytes.NewBufferString("data")); err != ErrBlockSize {
+			t.Fatalf("%d: got %v", size, err)
+		}
+	}
+	conn.Reset()
+	binary.Write(&conn, binary.BigEndian, _BlockHdr{MaxBlockSize + 1, HashFNV})
+	if err := (Config{Checksum: true}).Receive(&bytes.Buffer{}, &conn); err != ErrBlockSize {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestHash(t *testing.T) {
+	for _, h := range Hashes {
+		if parsed, err := ParseHash(strings.ToLower(h.String())); err != nil || parsed != h {
+			t.Fatalf("%v: parsed %v, %v", h, parsed, err)
+		}
+
+		var conn bytes.Buffer
+		config := Config{Checksum: true, Hash: h}
+		config.Send(&conn, bytes.NewBufferString("data"))
+		config.Send(&conn, bytes.NewBufferString("more data"))
+
+		// The receiver follows the algorithm in the header.
+		var file bytes.Buffer
+		if err := (Config{Checksum: true}).Receive(&file, &conn); err != nil || file.String() != "data" {
+			t.Fatalf("%v: got %q, %v", h, file.String(), err)
+		}
+		conn.Bytes()[9] ^= 1
+		if err := (Config{Checksum: true}).Receive(&file, &conn); err != ErrBrokenBlock {
+			t.Fatalf("%v: corrupted block should be broken", h)
+		}
+	}
+
+	var conn bytes.Buffer
+	if err := (Config{Checksum: true, Hash: 0xff}).Send(&conn, bytes.NewBufferString("data")); err != ErrUnknownHash {
+		t.Fatalf("got %v", err)
+	}
+	conn.Write([]byte{0, 0, 0, 0, 0, 0, 0, 4, 0xff})
+	if err := (Config{Checksum: true}).Receive(&bytes.Buffer{}, &conn); err != ErrUnknownHash {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestXXH64(t *testing.T) {
+	for input, sum := range map[string]uint64{
+		"":    0xef46db3751d8e999,
+		"a":   0xd24ec4f1a98c6e5b,
+		"abc": 0x44bc2cf5ad770999,
+		"Nobody inspects the spammish repetition": 0xfbcea83c8a378bf1,
+	} {
+		h := newXXH64()
+		// Written in pieces, across the stripes.
+		for i := 0; i < len(input); i += 5 {
+			end := i + 5
+			if end > len(input) {
+				end = len(input)
+			}
+			h.Write([]byte(input[i:end]))
+		}
+		if h.Sum64() != sum {
+			t.Fatalf("%q: got %x, want %x", input, h.Sum64(), sum)
+		}
+	}
+}
+
+func TestRestart(t *testing.T) {
+	for _, config := range []Config{{BlockSize: 4, RestartInterval: 4}, {BlockSize: 4, RestartInterval: 4, Checksum: true}} {
+		var conn bytes.Buffer
+		config.Send(&conn, bytes.NewBufferString("abcdefghij"))
+		data := conn.Bytes()
+
+		var markers []string
+		config.OnRestart = func(marker string) { markers = append(markers, marker) }
+		var file bytes.Buffer
+		if err := config.Receive(&file, bytes.NewReader(data)); err != nil || file.String() != "abcdefghij" {
+			t.Fatalf("%+v: got %q, %v", config, file.String(), err)
+		}
+		if fmt.Sprint(markers) != "[4 8]" {
+			t.Fatalf("%+v: got markers %v", config, markers)
+		}
+
+		// The connection breaks in the last block.
+		file.Reset()
+		err := config.Receive(&file, bytes.NewReader(data[:len(data)-1]))
+		var restartErr *RestartError
+		if !errors.As(err, &restartErr) || restartErr.Marker != "8" || !errors.Is(err, ErrBrokenBlock) {
+			t.Fatalf("%+v: got %v", config, err)
+		}
+		if file.String() != "abcdefgh" {
+			t.Fatalf("%+v: got %q before the marker", config, file.String())
+		}
+	}
+
+	// Resuming from a marker, the markers continue from its offset.
+	var conn bytes.Buffer
+	Config{BlockSize: 4, RestartInterval: 4, RestartOffset: 8}.Send(&conn, bytes.NewBufferString("ijklm"))
+	if !bytes.Contains(conn.Bytes(), []byte{DescriptorRestart, 0, 2, '1', '2'}) {
+		t.Fatalf("no marker 12 in % x", conn.Bytes())
+	}
+}
+
+type fileAt []byte
+
+func (f fileAt) WriteAt(p []byte, off int64) (int, error) {
+	return copy(f[off:], p), nil
+}
+
+func TestRepair(t *testing.T) {
+	config := Config{BlockSize: 4, Checksum: true, Repair: true, RestartInterval: 4}
+	original := []byte("abcdefghij")
+	var conn bytes.Buffer
+	config.Send(&conn, bytes.NewReader(original))
+
+	// The second block is damaged, after the header, the first block and its footer.
+	data := conn.Bytes()
+	data[9+4+17+1] ^= 1
+
+	var file bytes.Buffer
+	err := config.Receive(&file, &conn)
+	var damaged *DamagedError
+	if !errors.As(err, &damaged) || fmt.Sprint(damaged.Indexes) != "[1]" || damaged.Offset(1) != 4 {
+		t.Fatalf("got %v", err)
+	}
+	if file.String() != "abcd\x00\x00\x00\x00ij" {
+		t.Fatalf("got %q", file.String())
+	}
+
+	repaired := fileAt(file.Bytes())
+	conn.Reset()
+	config.Resend(&conn, bytes.NewReader(original), 1)
+	if err := config.ReceiveResent(repaired, 1, &conn); err != nil {
+		t.Fatal(err)
+	}
+	if string(repaired) != string(original) {
+		t.Fatalf("got %q", repaired)
+	}
+
+	// Without repair the damaged block fails the file.
+	conn.Reset()
+	config.Send(&conn, bytes.NewReader(original))
+	conn.Bytes()[9+4+17+1] ^= 1
+	if err := (Config{Checksum: true}).Receive(&bytes.Buffer{}, &conn); err != ErrBrokenBlock {
+		t.Fatalf("got %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package block
+
+import (
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"hash/fnv"
+	"strings"
+)
+
+// The checksum algorithm of the checksummed framing. The sender writes it in
+// the header of each file, so the receiver checks the blocks with the same
+// algorithm.
+type Hash byte
+
+const (
+	HashFNV    Hash = iota // FNV-1 64-bit, the default
+	HashCRC32C             // CRC-32 of the Castagnoli polynomial
+	HashXXH64              // xxHash 64-bit, of seed 0
+	HashSHA256
+)
+
+var ErrUnknownHash = errors.New("unknown block hash")
+
+// All the algorithms supported, by the identifier.
+var Hashes = []Hash{HashFNV, HashCRC32C, HashXXH64, HashSHA256}
+
+var hashNames = map[Hash]string{
+	HashFNV:    "FNV",
+	HashCRC32C: "CRC32C",
+	HashXXH64:  "XXH64",
+	HashSHA256: "SHA-256",
+}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+func (h Hash) String() string {
+	return hashNames[h]
+}
+
+// Parses the name of an algorithm as returned by String, in any case.
+func ParseHash(name string) (Hash, error) {
+	for h, hashName := range hashNames {
+		if strings.EqualFold(name, hashName) {
+			return h, nil
+		}
+	}
+	return 0, ErrUnknownHash
+}
+
+func (h Hash) new() (hash.Hash, error) {
+	switch h {
+	case HashFNV:
+		return fnv.New64(), nil
+	case HashCRC32C:
+		return crc32.New(crc32cTable), nil
+	case HashXXH64:
+		return newXXH64(), nil
+	case HashSHA256:
+		return sha256.New(), nil
+	default:
+		return nil, ErrUnknownHash
+	}
+}
@@ -0,0 +1,65 @@
+package block
+
+import (
+	"fmt"
+	"io"
+)
+
+// Returned by Receive with Repair when some blocks are damaged. The rest of
+// the file has been received, and each damaged block is written as zeros at
+// its offset, so the data is in place once the blocks are resent.
+type DamagedError struct {
+	// Indexes of the damaged data blocks, counted from 0 in the transfer.
+	Indexes []int64
+	// The offset the transfer started at, and its block size.
+	Start     int64
+	BlockSize int64
+}
+
+func (e *DamagedError) Error() string {
+	return fmt.Sprintf("%d blocks damaged", len(e.Indexes))
+}
+
+// Offset of the block of index in the file.
+func (e *DamagedError) Offset(index int64) int64 {
+	return e.Start + index*e.BlockSize
+}
+
+// Resends the block of index of a transfer of the file src in the
+// checksummed framing. The block is sent as a file of its own, which the
+// receiver writes by ReceiveResent.
+func (config Config) Resend(dst io.Writer, src io.ReaderAt, index int64) error {
+	size := config.blockSize()
+	return config.Send(dst, io.NewSectionReader(src, config.RestartOffset+index*size, size))
+}
+
+// Receives the block of index sent by Resend, and writes it at its offset in
+// the file dst. A damaged block is not repaired again, but returns
+// ErrBrokenBlock, so that it can be resent once more.
+func (config Config) ReceiveResent(dst io.WriterAt, index int64, src io.Reader) error {
+	config.Repair = false
+	r, err := config.newReader(src)
+	if err != nil {
+		return err
+	}
+
+	offset := config.RestartOffset + index*int64(len(r.block))
+	for {
+		data, descriptor, err := r.next()
+		if err != nil {
+			return err
+		}
+
+		if descriptor&DescriptorRestart != 0 {
+			continue
+		}
+		if _, err := dst.WriteAt(data, offset); err != nil {
+			return err
+		}
+		offset += int64(len(data))
+
+		if descriptor&DescriptorEOF != 0 {
+			return nil
+		}
+	}
+}
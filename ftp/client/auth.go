@@ -15,27 +15,33 @@ func (client *clientImpl) Login(username, password string) error {
 		if code == cmd.NEED_ACCOUNT {
 			return ErrUsernameNotExist
 		}
-		return errors.New(msg)
+		return replyError(code, msg, err)
 	}
 
 	if code, msg, err := client.cmd(cmd.LOGIN_PROCEED, "PASS %s", password); err != nil {
 		if code == cmd.NOT_LOGIN {
 			return ErrPasswordNotMatch
 		}
-		return errors.New(msg)
+		return replyError(code, msg, err)
 	}
 
 	client.username = username
+	client.password = password
 
-	return nil
+	// A session starts in TYPE A, which converts line endings and so damages
+	// any file which is not text. Most servers refuse TYPE before the login,
+	// so the type of the client, binary unless another is set, is sent now.
+	return client.Type(client.type_)
 }
 
 func (client *clientImpl) Logout() error {
-	if _, msg, err := client.cmd(cmd.CTRL_CONN_CLOSE, "QUIT"); err != nil {
-		return errors.New(msg)
+	client.SetKeepalive(0)
+	if code, msg, err := client.cmd(cmd.CTRL_CONN_CLOSE, "QUIT"); err != nil {
+		return replyError(code, msg, err)
 	}
 
 	client.username = ""
+	client.password = ""
 
 	return nil
 }
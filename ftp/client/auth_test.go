@@ -16,6 +16,7 @@ func TestLogin(t *testing.T) {
 		server := textproto.NewConn(conn)
 		defer server.Close()
 		server.Writer.PrintfLine("220 Service ready for new user.")
+		answerFeat(server)
 
 		if line, _ := server.ReadLine(); strings.HasPrefix(line, "USER") {
 			server.Writer.PrintfLine("331 User name okay, need password.")
@@ -25,6 +26,10 @@ func TestLogin(t *testing.T) {
 			server.Writer.PrintfLine("230 User logged in, proceed.")
 		}
 
+		if line, _ := server.ReadLine(); strings.HasPrefix(line, "TYPE I") {
+			server.Writer.PrintfLine("200 Command okay.")
+		}
+
 	}()
 
 	client, _ := NewFtpClient("localhost:8965")
@@ -45,6 +50,7 @@ func TestUsernameNotExist(t *testing.T) {
 		server := textproto.NewConn(conn)
 		defer server.Close()
 		server.Writer.PrintfLine("220 Service ready for new user.")
+		answerFeat(server)
 
 		if line, _ := server.ReadLine(); strings.HasPrefix(line, "USER") {
 			server.Writer.PrintfLine("332 Need account for login.")
@@ -69,6 +75,7 @@ func TestPasswordError(t *testing.T) {
 		server := textproto.NewConn(conn)
 		defer server.Close()
 		server.Writer.PrintfLine("220 Service ready for new user.")
+		answerFeat(server)
 
 		if line, _ := server.ReadLine(); strings.HasPrefix(line, "USER") {
 			server.Writer.PrintfLine("331 User name okay, need password.")
@@ -97,6 +104,7 @@ func TestLogout(t *testing.T) {
 		server := textproto.NewConn(conn)
 		defer server.Close()
 		server.Writer.PrintfLine("220 Service ready for new user.")
+		answerFeat(server)
 
 		if line, _ := server.ReadLine(); strings.HasPrefix(line, "USER") {
 			server.Writer.PrintfLine("331 User name okay, need password.")
@@ -106,6 +114,10 @@ func TestLogout(t *testing.T) {
 			server.Writer.PrintfLine("230 User logged in, proceed.")
 		}
 
+		if line, _ := server.ReadLine(); strings.HasPrefix(line, "TYPE I") {
+			server.Writer.PrintfLine("200 Command okay.")
+		}
+
 		if line, _ := server.ReadLine(); strings.HasPrefix(line, "QUIT") {
 			server.Writer.PrintfLine("221 Service closing control connection.")
 		}
@@ -125,3 +137,64 @@ func TestLogout(t *testing.T) {
 		t.Fatal("username should be empty")
 	}
 }
+
+// The type is sent once logged in, as servers refuse it before, and a refused
+// type fails the login.
+func TestLoginType(t *testing.T) {
+	lines := make(chan string, 16)
+	listener, _ := net.Listen("tcp", ":8993")
+	go func() {
+		conn, _ := listener.Accept()
+		listener.Close()
+		server := textproto.NewConn(conn)
+		defer server.Close()
+		server.Writer.PrintfLine("220 Service ready for new user.")
+		answerFeat(server)
+
+		var username string
+		login := false
+		for {
+			line, err := server.ReadLine()
+			if err != nil {
+				return
+			}
+			lines <- line
+			command := strings.SplitN(line, " ", 2)
+			switch command[0] {
+			case "USER":
+				username, login = command[1], false
+				server.Writer.PrintfLine("331 User name okay, need password.")
+			case "PASS":
+				// The session of limited is not logged in all the same.
+				login = username != "limited"
+				server.Writer.PrintfLine("230 User logged in, proceed.")
+			case "TYPE":
+				if !login {
+					server.Writer.PrintfLine("530 Not logged in.")
+					break
+				}
+				server.Writer.PrintfLine("200 Command okay.")
+			}
+		}
+	}()
+
+	client, err := NewFtpClient("localhost:8993")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Login("user", "pass"); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for len(lines) > 0 {
+		got = append(got, <-lines)
+	}
+	if strings.Join(got, ",") != "USER user,PASS pass,TYPE I" || client.GetType() != TypeBinary {
+		t.Fatalf("sent %q", got)
+	}
+
+	var reply *ReplyError
+	if err := client.Login("limited", "pass"); !errors.As(err, &reply) || reply.Code != 530 {
+		t.Fatalf("got %v", err)
+	}
+}
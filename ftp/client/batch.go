@@ -0,0 +1,225 @@
+package client
+
+import (
+	"errors"
+	"ftp/block"
+	"ftp/cmd"
+	"ftp/repr"
+	"io"
+	"os"
+	"path"
+)
+
+var (
+	ErrBatchNotSupported = errors.New("batch transfers need block mode and file structure")
+	ErrBatchAborted      = errors.New("batch aborted by an earlier failure")
+)
+
+// A list of files transferred in one batch, and the result of each file after
+// the transfer.
+type Batch struct {
+	files []batchFile
+}
+
+type batchFile struct {
+	local, remote string
+	err           error
+}
+
+func NewBatch() *Batch {
+	return &Batch{}
+}
+
+func (b *Batch) Add(local, remote string) {
+	b.files = append(b.files, batchFile{local: local, remote: remote})
+}
+
+func (b *Batch) Len() int {
+	return len(b.files)
+}
+
+func (b *Batch) Local(i int) string {
+	return b.files[i].local
+}
+
+func (b *Batch) Remote(i int) string {
+	return b.files[i].remote
+}
+
+// The result of the file of index i, nil if it has been transferred.
+func (b *Batch) Err(i int) error {
+	return b.files[i].err
+}
+
+// Stores the files of the batch over one data connection in block mode. The
+// commands and the files are sent without waiting for the replies, which are
+// read as they come. The result of each file is kept in the batch, the error
+// returned is of the batch as a whole.
+//
+// Damaged blocks can not be resent in a pipeline, so a damaged file fails.
+func (client *clientImpl) StoreMany(batch *Batch) error {
+	if err := client.startBatch(); err != nil {
+		return err
+	}
+	defer client.holdKeepalive()()
+
+	// The indexes of the files sent, in the order of the replies.
+	sent := make(chan int, len(batch.files))
+	broken := false
+	go func() {
+		defer close(sent)
+		for i := range batch.files {
+			file := &batch.files[i]
+			if broken {
+				file.err = ErrBatchAborted
+				continue
+			}
+
+			localFile, err := os.Open(path.Join(client.rootDir, file.local))
+			if err != nil {
+				file.err = err
+				continue
+			}
+			if err = client.ctrlConn.PrintfLine("STOR %s", file.remote); err == nil {
+				sent <- i
+				err = client.blockConfig.Send(client.dataConn, repr.NewReader(localFile, client.type_))
+			}
+			localFile.Close()
+
+			// The rest of the files can not follow a broken one, and the
+			// server finds it broken as the data connection is closed.
+			if err != nil {
+				client.dataConn.Close()
+				broken = true
+			}
+		}
+	}()
+
+	// A transfer failed after it started has closed the data connection on
+	// the server, but a refused one or a damaged one has not.
+	failed := false
+	for i := range sent {
+		file := &batch.files[i]
+		if code, msg, err := client.ctrlConn.ReadResponse(cmd.ALREADY_OPEN); err != nil {
+			file.err = replyError(code, msg, err)
+			continue
+		}
+		if code, msg, err := client.readTransferResponse(); err != nil {
+			file.err = replyError(code, msg, err)
+			failed = failed || code != cmd.StatusFileActionPending
+		}
+	}
+	if broken || failed {
+		client.closeDataConn()
+	}
+
+	client.verifyBatch(batch)
+	return nil
+}
+
+// Retrieves the files of the batch over one data connection in block mode.
+// The commands are sent without waiting for the replies. As in StoreMany, the
+// result of each file is kept in the batch.
+func (client *clientImpl) RetrieveMany(batch *Batch) error {
+	if err := client.startBatch(); err != nil {
+		return err
+	}
+	defer client.holdKeepalive()()
+
+	sent := make(chan int, len(batch.files))
+	go func() {
+		defer close(sent)
+		for i, file := range batch.files {
+			if err := client.ctrlConn.PrintfLine("RETR %s", file.remote); err != nil {
+				return
+			}
+			sent <- i
+		}
+	}()
+
+	broken := false
+	replied := 0
+	for i := range sent {
+		replied++
+		file := &batch.files[i]
+		if code, msg, err := client.ctrlConn.ReadResponse(cmd.ALREADY_OPEN); err != nil {
+			file.err = replyError(code, msg, err)
+			continue
+		}
+
+		if broken {
+			file.err = ErrBatchAborted
+		} else {
+			file.err = client.receiveBatchFile(file.local)
+			var damaged *block.DamagedError
+			// Only a damaged file is received to its end.
+			if file.err != nil && !errors.As(file.err, &damaged) {
+				client.closeDataConn()
+				broken = true
+			}
+		}
+
+		if code, msg, err := client.readTransferResponse(); err != nil && file.err == nil {
+			file.err = replyError(code, msg, err)
+		}
+	}
+	// The commands not sent for a broken control connection.
+	for i := replied; i < len(batch.files); i++ {
+		batch.files[i].err = ErrBatchAborted
+	}
+
+	client.verifyBatch(batch)
+	return nil
+}
+
+// Receives a file of RETR, which is received all the same when the local file
+// can not be written, to keep the data connection in step.
+func (client *clientImpl) receiveBatchFile(local string) error {
+	p := path.Join(client.rootDir, local)
+	var dst io.Writer = io.Discard
+	localErr := os.MkdirAll(path.Dir(p), 0777)
+	var localFile *os.File
+	if localErr == nil {
+		localFile, localErr = os.Create(p)
+	}
+	if localErr == nil {
+		defer localFile.Close()
+		dst = localFile
+	}
+
+	w := repr.NewWriter(dst, client.type_)
+	if err := client.blockConfig.Receive(w, client.dataConn); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return localErr
+}
+
+// Pipelining is agreed on with the server before the first batch, which
+// needs a data connection in block mode.
+func (client *clientImpl) startBatch() error {
+	if client.mode != ModeBlock || client.stru != StruFile {
+		return ErrBatchNotSupported
+	}
+	if !client.pipeline {
+		if code, msg, err := client.cmd(cmd.OK, "OPTS MODE B PIPELINE ON"); err != nil {
+			return replyError(code, msg, err)
+		}
+		client.pipeline = true
+	}
+
+	client.restartMarker = ""
+	client.blockConfig.RestartOffset = 0
+	return client.createDataConn()
+}
+
+// Verifies the files transferred with SetVerify, after the pipeline is done.
+func (client *clientImpl) verifyBatch(batch *Batch) {
+	for i := range batch.files {
+		if file := &batch.files[i]; file.err == nil {
+			file.err = client.verifyTransfer(file.local, file.remote)
+		}
+	}
+}
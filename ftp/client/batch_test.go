@@ -0,0 +1,133 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"ftp/block"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	os.Mkdir("_test_", 0777)
+	defer os.RemoveAll("_test_")
+
+	lines := make(chan string, 16)
+	listener, _ := net.Listen("tcp", ":8983")
+	go func() {
+		conn, _ := listener.Accept()
+		listener.Close()
+		server := textproto.NewConn(conn)
+		defer server.Close()
+
+		var dataConn net.Conn
+		files := make(map[string][]byte)
+
+		server.Writer.PrintfLine("220 Service ready for new user.")
+		for {
+			line, err := server.ReadLine()
+			if err != nil {
+				return
+			}
+			lines <- line
+			command := strings.SplitN(line, " ", 2)
+			switch command[0] {
+			case "PORT":
+				var h1, h2, h3, h4, p1, p2 byte
+				fmt.Sscanf(line, "PORT %d,%d,%d,%d,%d,%d", &h1, &h2, &h3, &h4, &p1, &p2)
+				dataConn, _ = net.Dial("tcp", fmt.Sprintf("%d.%d.%d.%d:%d", h1, h2, h3, h4, int(p1)*256+int(p2)))
+				server.Writer.PrintfLine("200 Command okay.")
+			case "STOR":
+				// A refused file is dropped, as it is sent all the same.
+				var data bytes.Buffer
+				block.Receive(&data, dataConn)
+				if command[1] == "refused" {
+					server.Writer.PrintfLine("550 File unavailable.")
+					break
+				}
+				files[command[1]] = data.Bytes()
+				server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+				server.Writer.PrintfLine("250 Requested file action okay, completed.")
+			case "RETR":
+				data, has := files[command[1]]
+				if !has {
+					server.Writer.PrintfLine("550 File unavailable.")
+					break
+				}
+				server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+				block.Send(dataConn, bytes.NewReader(data))
+				server.Writer.PrintfLine("250 Requested file action okay, completed.")
+			default:
+				server.Writer.PrintfLine("200 Command okay.")
+			}
+		}
+	}()
+
+	client, err := NewFtpClient("localhost:8983")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Type(TypeBinary)
+	if err := client.StoreMany(NewBatch()); err != ErrBatchNotSupported {
+		t.Fatalf("got %v", err)
+	}
+	client.Mode(ModeBlock)
+
+	names := []string{"small.txt", "small2.txt", "small9993"}
+	stores := NewBatch()
+	for _, name := range names {
+		stores.Add("test_files/"+name, name)
+	}
+	stores.Add("test_files/small.txt", "refused")
+	stores.Add("test_files/none", "none")
+	if err := client.StoreMany(stores); err != nil {
+		t.Fatal(err)
+	}
+	for i := range names {
+		if err := stores.Err(i); err != nil {
+			t.Fatalf("%s: %v", stores.Local(i), err)
+		}
+	}
+	if stores.Err(3) == nil || !os.IsNotExist(stores.Err(4)) {
+		t.Fatalf("got %v, %v", stores.Err(3), stores.Err(4))
+	}
+
+	retrieves := NewBatch()
+	for _, name := range names {
+		retrieves.Add("_test_/"+name, name)
+	}
+	retrieves.Add("_test_/refused", "refused")
+	if err := client.RetrieveMany(retrieves); err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range names {
+		if err := retrieves.Err(i); err != nil {
+			t.Fatalf("%s: %v", retrieves.Remote(i), err)
+		}
+		local, _ := os.ReadFile("test_files/" + name)
+		if data, _ := os.ReadFile("_test_/" + name); !bytes.Equal(data, local) {
+			t.Fatalf("%s not equal", name)
+		}
+	}
+	if retrieves.Err(3) == nil {
+		t.Fatal("a missing file should fail")
+	}
+
+	// Pipelining is agreed on once, and one data connection is used for all.
+	ports := 0
+	for len(lines) > 0 {
+		line := <-lines
+		if strings.HasPrefix(line, "PORT") {
+			ports++
+		}
+		if line == "OPTS MODE B PIPELINE ON" && ports != 0 {
+			t.Fatal("pipelining agreed on after the data connection")
+		}
+	}
+	if ports != 1 {
+		t.Fatalf("%d data connections", ports)
+	}
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"bytes"
+	"ftp/server"
+	"os"
+	"testing"
+)
+
+// A file which is not text is transferred as it is with the default settings,
+// though it has line endings in it.
+func TestDefaultBinary(t *testing.T) {
+	os.Mkdir("_binary_", 0777)
+	defer os.RemoveAll("_binary_")
+	file := []byte("\x89PNG\r\n\x1a\n\x00\r\n\r\r\n\n\xff")
+	os.WriteFile("_binary_/local.png", file, 0666)
+
+	s := server.NewFtpServer()
+	s.SetRootDir("_binary_")
+	if err := s.Listen(8991); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewFtpClient("localhost:8991")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Login("test", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if client.GetType() != TypeBinary {
+		t.Fatalf("got type %c", client.GetType())
+	}
+
+	if err := client.Store("_binary_/local.png", "remote.png"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Retrieve("_binary_/retrieved.png", "remote.png"); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := os.ReadFile("_binary_/remote.png"); !bytes.Equal(stored, file) {
+		t.Fatalf("stored %q", stored)
+	}
+	if retrieved, _ := os.ReadFile("_binary_/retrieved.png"); !bytes.Equal(retrieved, file) {
+		t.Fatalf("retrieved %q", retrieved)
+	}
+}
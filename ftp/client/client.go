@@ -1,8 +1,14 @@
 package client
 
 import (
+	"compress/zlib"
+	"errors"
+	"ftp/block"
+	"ftp/rate"
 	"net"
 	"net/textproto"
+	"sync"
+	"time"
 )
 
 type FtpClient interface {
@@ -12,12 +18,21 @@ type FtpClient interface {
 
 	ConnMode(byte) error
 	GetConnMode() byte
-	SetBlockSize(int64)
+	SetBlockSize(blockSize int64) error
+	// Bandwidth limit of data connections, 0 means unlimited.
+	SetRateLimit(bytesPerSecond int64)
 
 	Mode(mode byte) error
 	GetMode() byte
+	SetDeflateLevel(level int) error
+	// Uses the checksummed block framing instead of RFC 959 in MODE B.
+	SetBlockChecksum(enabled bool) error
+	SetBlockHash(algorithm string) error
+	// Resends the damaged blocks of the checksummed framing, see XBLK.
+	SetBlockRepair(enabled bool) error
 
 	Type(type_ byte) error
+	TypeForm(type_, form byte) error
 	GetType() byte
 
 	Structure(stru byte) error
@@ -26,6 +41,56 @@ type FtpClient interface {
 	SetRootDir(string)
 	Store(local, remote string) error
 	Retrieve(local, remote string) error
+	Size(remote string) (int64, error)
+
+	// Resumable transfers. In block mode a restart marker is sent every
+	// interval bytes, 0 sends none. After a failed transfer GetRestartMarker
+	// returns the last marker acknowledged, where it can be resumed in a
+	// binary type.
+	SetRestartInterval(bytes int64)
+	GetRestartMarker() string
+	RestartStore(local, remote, marker string) error
+	RestartRetrieve(local, remote, marker string) error
+
+	// Retries after transient failures, see SetRetryPolicy. Reconnect
+	// connects and logs in again with the same session parameters.
+	SetRetryPolicy(maxRetries, milliseconds int)
+	Reconnect() error
+
+	// The extensions listed by the server in reply to FEAT, which is sent
+	// once connected. EPSV, MODE Z, HASH and RANG are used by them. MLSD is
+	// out of scope, there are no listing commands on either side.
+	HasFeature(name string) bool
+	Feature(name string) string
+
+	// NOOP. Ping measures the round trip of a NOOP in milliseconds, and a
+	// keepalive sends NOOP after each interval without a command, also
+	// during long transfers, see SetKeepalive.
+	Noop() error
+	Ping() (int64, error)
+	SetKeepalive(milliseconds int)
+
+	// Whole-file integrity. Checksum is the digest of the remote file by
+	// HASH, and Verify compares it with the local file. With SetVerify each
+	// file stored or retrieved in file structure is verified.
+	SetHashAlgorithm(algorithm string) error
+	Checksum(remote string) (string, error)
+	Verify(local, remote string) error
+	SetVerify(enabled bool)
+
+	// Batch transfers of many files over one data connection in block
+	// mode, see Batch.
+	StoreMany(batch *Batch) error
+	RetrieveMany(batch *Batch) error
+
+	// Retrieves a large file in ranges over several sessions at once, see
+	// RetrieveParallel.
+	SetParallelStreams(n int)
+	RetrieveParallel(local, remote string) error
+
+	// Record level transfers in record structure.
+	StoreRecords(remote string, records RecordReader) error
+	RetrieveRecords(remote string, records RecordWriter) error
 }
 
 func NewFtpClient(addr string) (FtpClient, error) {
@@ -40,20 +105,30 @@ func NewFtpClient(addr string) (FtpClient, error) {
 
 func defaultFtpClient() *clientImpl {
 	return &clientImpl{
-		ctrlConn: nil,
-		dataConn: nil,
-		username: "",
-		connMode: ConnPort,
-		mode:     ModeStream,
-		type_:    TypeAscii,
-		stru:     StruFile,
-		rootDir:  "",
+		ctrlConn:     nil,
+		dataConn:     nil,
+		username:     "",
+		connMode:     ConnPort,
+		mode:         ModeStream,
+		type_:        TypeBinary, // sent at the login
+		stru:         StruFile,
+		rootDir:      "",
+		limiter:      rate.NewLimiter(0),
+		deflateLevel: zlib.DefaultCompression,
+		blockConfig:  block.Config{RestartInterval: DefaultRestartInterval},
+
+		hashAlgorithm: DefaultHashAlgorithm,
+
+		parallelStreams: DefaultParallelStreams,
+		retryBackoff:    DefaultRetryBackoff,
+		ctrl:            &ctrlState{},
 	}
 }
 
 var _ FtpClient = (*clientImpl)(nil)
 
 type clientImpl struct {
+	addr     string
 	ctrlConn *textproto.Conn
 	dataConn net.Conn
 	username string
@@ -62,12 +137,82 @@ type clientImpl struct {
 	type_    byte
 	stru     byte
 	rootDir  string
+	limiter  *rate.Limiter
+
+	deflateLevel int
+	blockConfig  block.Config
+
+	restartMarker string
+
+	hashAlgorithm string
+	verify        bool
+
+	pipeline bool // agreed on with the server for batches
+
+	password        string // to log in the sessions of parallel transfers
+	parallelStreams int
+
+	onTransfer      func(n int64)       // counts the bytes of local files transferred
+	onAttempt       func(offset int64)  // each attempt of a file, at the offset it resumes
+	onRestartMarker func(marker string) // each marker acknowledged in a store
+
+	maxRetries   int
+	retryBackoff time.Duration
+
+	ctrl *ctrlState
+
+	features map[string]string // by FEAT, nil if unknown
+}
+
+// The use of the control connection, shared with the keepalive.
+type ctrlState struct {
+	sync.Mutex   // held for a command and its reply
+	lastCmd      time.Time
+	transferring bool
+	busy         int // keepalive is held off while it is not 0
+	noops        int // NOOPs sent in a transfer, whose replies follow it
+	keepalive    chan struct{}
+}
+
+// A reply of the server other than the one expected, its text is that of
+// the reply.
+type ReplyError struct {
+	Code int
+	Msg  string
+}
+
+func (e *ReplyError) Error() string {
+	return e.Msg
+}
+
+// A 4xx reply, the command may succeed when it is sent again.
+func (e *ReplyError) Transient() bool {
+	return e.Code/100 == 4
+}
+
+// The error of a command whose reply was not the one expected, or err itself
+// if no reply has been read.
+func replyError(code int, msg string, err error) error {
+	var protocolErr *textproto.Error
+	if !errors.As(err, &protocolErr) {
+		return err
+	}
+	return &ReplyError{Code: code, Msg: msg}
 }
 
 func (client *clientImpl) cmd(expect int, cmd string, args ...interface{}) (int, string, error) {
+	client.ctrl.Lock()
+	defer client.ctrl.Unlock()
+	client.ctrl.lastCmd = time.Now()
+
 	if _, err := client.ctrlConn.Cmd(cmd, args...); err != nil {
 		return 0, "", err
 	}
 
-	return client.ctrlConn.ReadResponse(expect)
+	code, msg, err := client.ctrlConn.ReadResponse(expect)
+	// A transfer has started, until its reply the server reads no command.
+	if err == nil && code/100 == 1 {
+		client.ctrl.transferring = true
+	}
+	return code, msg, err
 }
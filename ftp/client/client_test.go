@@ -13,6 +13,7 @@ func TestNewClient(t *testing.T) {
 		listener.Close()
 		server := textproto.NewConn(conn)
 		server.Writer.PrintfLine("220 Service ready for new user.")
+		answerFeat(server)
 		server.Close()
 	}()
 
@@ -21,3 +22,10 @@ func TestNewClient(t *testing.T) {
 		t.Fatal("client is nil")
 	}
 }
+
+// Answers the FEAT sent by a new client as a server without it, so that the
+// client tries each extension when it is used.
+func answerFeat(server *textproto.Conn) {
+	server.ReadLine()
+	server.Writer.PrintfLine("500 Syntax error, command unrecognized.")
+}
@@ -2,6 +2,7 @@ package client
 
 import (
 	"ftp/cmd"
+	"ftp/rate"
 	"net"
 	"net/textproto"
 	"strconv"
@@ -18,8 +19,14 @@ func (client *clientImpl) createCtrlConn(addr string) error {
 		return err
 	}
 
+	client.addr = addr
 	client.ctrlConn = conn
 
+	if err := client.feat(); err != nil {
+		conn.Close()
+		return err
+	}
+
 	return nil
 }
 
@@ -31,19 +38,30 @@ func (client *clientImpl) createDataConn() (err error) {
 	var conn net.Conn
 	switch client.connMode {
 	case ConnPasv:
-		conn, err = client.pasvDataConn()
+		if client.advertised("EPSV", "") {
+			conn, err = client.epsvDataConn()
+		} else {
+			conn, err = client.pasvDataConn()
+		}
 	case ConnPort:
 		conn, err = client.portDataConn()
 	default:
 		err = ErrConnModeNotSupported
 	}
+	if err != nil {
+		return
+	}
 
-	client.dataConn = conn
+	client.dataConn = rate.NewConn(conn, client.limiter)
 
 	return
 
 }
 
+func (client *clientImpl) SetRateLimit(bytesPerSecond int64) {
+	client.limiter.SetRate(bytesPerSecond)
+}
+
 func (client *clientImpl) closeDataConn() (err error) {
 	if client.dataConn != nil {
 		err = client.dataConn.Close()
@@ -113,3 +131,33 @@ func (client *clientImpl) pasvDataConn() (net.Conn, error) {
 
 	return net.Dial("tcp4", addr)
 }
+
+// EPSV, as in RFC 2428, used instead of PASV when the server lists it. The
+// reply has only the port, the host is that of the control connection, so it
+// works over IPv6 and behind NAT.
+func (client *clientImpl) epsvDataConn() (net.Conn, error) {
+	code, msg, err := client.cmd(cmd.StatusEnteringEpsvMode, "EPSV")
+	if err != nil {
+		return nil, replyError(code, msg, err)
+	}
+
+	// (<d><d><d><port><d>), the delimiter is usually |
+	start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+	if start == -1 || end < start+5 {
+		return nil, ErrInvalidPasvResponse
+	}
+	fields := strings.Split(msg[start+2:end-1], msg[start+1:start+2])
+	if len(fields) != 3 {
+		return nil, ErrInvalidPasvResponse
+	}
+	port, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, ErrInvalidPasvResponse
+	}
+
+	host, _, err := net.SplitHostPort(client.addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+}
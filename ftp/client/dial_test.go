@@ -17,6 +17,7 @@ func TestConnMode(t *testing.T) {
 		server := textproto.NewConn(conn)
 		defer server.Close()
 		server.Writer.PrintfLine("220 Service ready for new user.")
+		answerFeat(server)
 
 		for {
 			if line, _ := server.ReadLine(); strings.HasPrefix(line, "PASV") {
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"errors"
+	"ftp/cmd"
+	"net/textproto"
+	"strings"
+)
+
+// Asks the server for its extensions by FEAT, as in RFC 2389, once the
+// connection is established. A server which does not reply 211 leaves them
+// unknown, and each extension is then tried when it is used, as servers
+// without FEAT often have some, XMD5 for one.
+func (client *clientImpl) feat() error {
+	client.features = nil
+	_, msg, err := client.cmd(cmd.StatusSystemStatus, "FEAT")
+	if err != nil {
+		var protocolErr *textproto.Error
+		if errors.As(err, &protocolErr) {
+			return nil
+		}
+		return err
+	}
+
+	// 211-<text>
+	//  <feature> [<params>]
+	// 211 <text>
+	features := make(map[string]string)
+	for _, line := range strings.Split(msg, "\n") {
+		if !strings.HasPrefix(line, " ") {
+			continue
+		}
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 2)
+		name, params := strings.ToUpper(fields[0]), ""
+		if len(fields) == 2 {
+			params = fields[1]
+		}
+		// A feature may be listed once for each of its options, as MODE.
+		if previous, has := features[name]; has {
+			params = previous + "\n" + params
+		}
+		features[name] = params
+	}
+	client.features = features
+	return nil
+}
+
+// Whether the server listed the extension in reply to FEAT, the name is not
+// case sensitive. False if the server did not reply to FEAT. MLSD is not used
+// even when listed, listing directories is out of scope.
+func (client *clientImpl) HasFeature(name string) bool {
+	_, has := client.features[strings.ToUpper(name)]
+	return has
+}
+
+// The parameters the server listed for the extension, one line for each time
+// it is listed. Empty if it has none or is not listed.
+func (client *clientImpl) Feature(name string) string {
+	return client.features[strings.ToUpper(name)]
+}
+
+// Whether the server listed the feature with parameters starting with params.
+func (client *clientImpl) advertised(name, params string) bool {
+	list, has := client.features[name]
+	if !has {
+		return false
+	}
+	for _, line := range strings.Split(list, "\n") {
+		if strings.HasPrefix(strings.ToUpper(line), params) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the server may have the feature, it is listed or the features are
+// unknown.
+func (client *clientImpl) mayHave(name, params string) bool {
+	return client.features == nil || client.advertised(name, params)
+}
+
+// Whether the server lists the algorithm for HASH, the selected one is marked
+// by a *.
+func (client *clientImpl) hashAdvertised(algorithm string) bool {
+	for _, name := range strings.Split(client.features["HASH"], ";") {
+		if strings.TrimSuffix(strings.ToUpper(name), "*") == algorithm {
+			return true
+		}
+	}
+	return false
+}
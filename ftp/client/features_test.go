@@ -0,0 +1,112 @@
+package client
+
+import (
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFeatures(t *testing.T) {
+	os.Mkdir("_test_", 0777)
+	defer os.RemoveAll("_test_")
+
+	lines := make(chan string, 64)
+	listener, _ := net.Listen("tcp", ":8990")
+	go func() {
+		conn, _ := listener.Accept()
+		listener.Close()
+		server := textproto.NewConn(conn)
+		defer server.Close()
+
+		var dataConn net.Conn
+		server.Writer.PrintfLine("220 Service ready for new user.")
+		for {
+			line, err := server.ReadLine()
+			if err != nil {
+				return
+			}
+			lines <- line
+			switch strings.SplitN(line, " ", 2)[0] {
+			case "FEAT":
+				server.Writer.PrintfLine("211-Extensions supported:\r\n EPSV\r\n MODE B CHECKSUM\r\n MODE B HASH FNV\r\n XMD5\r\n211 End.")
+			case "EPSV":
+				dataListener, _ := net.Listen("tcp", ":0")
+				server.Writer.PrintfLine("229 Entering Extended Passive Mode (|||%d|).", dataListener.Addr().(*net.TCPAddr).Port)
+				dataConn, _ = dataListener.Accept()
+				dataListener.Close()
+			case "RETR":
+				server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+				dataConn.Write([]byte("features"))
+				dataConn.Close()
+				server.Writer.PrintfLine("250 Requested file action okay, completed.")
+			case "XMD5":
+				server.Writer.PrintfLine("250 7b0a2b3f1c4d5e6f7a8b9c0d1e2f3a4b")
+			default:
+				server.Writer.PrintfLine("200 Command okay.")
+			}
+		}
+	}()
+	sent := func() (got []string) {
+		for len(lines) > 0 {
+			got = append(got, <-lines)
+		}
+		return
+	}
+
+	client, err := NewFtpClient("localhost:8990")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !client.HasFeature("xmd5") || client.Feature("XMD5") != "" || client.HasFeature("HASH") {
+		t.Fatal("XMD5 should be listed without parameters, HASH not at all")
+	}
+	if mode := client.Feature("MODE"); mode != "B CHECKSUM\nB HASH FNV" {
+		t.Fatalf("got MODE %q", mode)
+	}
+	sent()
+
+	// The extensions which are not listed are not sent.
+	if err := client.Mode(ModeDeflate); err != ErrModeNotSupported {
+		t.Fatalf("got %v", err)
+	}
+	if err := client.SetHashAlgorithm("SHA-256"); err != ErrHashNotSupported {
+		t.Fatalf("got %v", err)
+	}
+	if got := sent(); len(got) != 0 {
+		t.Fatalf("sent %q", got)
+	}
+	if err := client.SetBlockChecksum(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetBlockChecksum(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without HASH the command of the algorithm is used.
+	if err := client.SetHashAlgorithm("MD5"); err != nil {
+		t.Fatal(err)
+	}
+	sent()
+	if digest, err := client.Checksum("features"); err != nil || digest != "7b0a2b3f1c4d5e6f7a8b9c0d1e2f3a4b" {
+		t.Fatalf("got %s, %v", digest, err)
+	}
+	if got := strings.Join(sent(), ","); got != "XMD5 features" {
+		t.Fatalf("sent %s", got)
+	}
+
+	// EPSV instead of PASV, and without RANG the file is retrieved whole.
+	client.ConnMode(ConnPasv)
+	client.Type(TypeBinary)
+	sent()
+	if err := client.RetrieveParallel("_test_/features", "features"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(sent(), ","); got != "EPSV,RETR features" {
+		t.Fatalf("sent %s", got)
+	}
+	if data, _ := os.ReadFile("_test_/features"); string(data) != "features" {
+		t.Fatalf("got %q", data)
+	}
+}
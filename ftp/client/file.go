@@ -1,12 +1,18 @@
 package client
 
 import (
+	"compress/zlib"
 	"errors"
 	"ftp/block"
 	"ftp/cmd"
+	"ftp/compressed"
+	"ftp/record"
+	"ftp/repr"
 	"io"
 	"os"
 	"path"
+	"strconv"
+	"strings"
 )
 
 var (
@@ -17,8 +23,19 @@ func (client *clientImpl) SetRootDir(rootDir string) {
 	client.rootDir = rootDir
 }
 
-func (clientImpl) SetBlockSize(blockSize int64) {
-	block.SetBlockSize(blockSize)
+// Sets the size of the blocks sent in block mode by both sides, up to
+// block.MaxBlockSize.
+func (client *clientImpl) SetBlockSize(blockSize int64) error {
+	if blockSize <= 0 || blockSize > block.MaxBlockSize {
+		return block.ErrBlockSize
+	}
+
+	if code, msg, err := client.cmd(cmd.OK, "OPTS MODE B BLOCKSIZE %d", blockSize); err != nil {
+		return replyError(code, msg, err)
+	}
+
+	client.blockConfig.BlockSize = blockSize
+	return nil
 }
 
 func (client *clientImpl) Store(local, remote string) (err error) {
@@ -60,33 +77,71 @@ func (client *clientImpl) StoreDir(localdir, remotedir string) error {
 	return nil
 }
 
-func (client *clientImpl) StoreFile(local, remote string) (err error) {
+// Stores the local file from offset, which is restarted at offset on the
+// server if it is not 0.
+func (client *clientImpl) storeFile(local, remote string, offset int64) (err error) {
+	// A failure before the transfer starts leaves no marker of this file.
+	client.restartMarker = ""
 	localFile, err := os.Open(path.Join(client.rootDir, local))
 	if err != nil {
 		return err
 	}
 	defer localFile.Close()
 
+	if offset > 0 {
+		if _, err := localFile.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
 	if err := client.createDataConn(); err != nil {
 		return err
 	}
 
-	if _, msg, err := client.cmd(cmd.ALREADY_OPEN, "STOR %s", remote); err != nil {
-		return errors.New(msg)
+	if err := client.restart(offset); err != nil {
+		return err
+	}
+
+	if code, msg, err := client.cmd(cmd.ALREADY_OPEN, "STOR %s", remote); err != nil {
+		return replyError(code, msg, err)
 	}
+	readResponse := client.readStoreResponse()
 
-	switch client.GetMode() {
-	case ModeStream:
-		err = client.storeStreamMode(localFile)
-	case ModeBlock:
-		err = client.storeBlockMode(localFile)
-	default:
-		err = ErrModeNotSupported
+	src := repr.NewReader(client.countReader(localFile), client.type_)
+	if client.stru == StruRecord {
+		err = client.storeRecords(record.NewLineReader(src, repr.Newline(client.type_)))
+	} else {
+		switch client.GetMode() {
+		case ModeStream:
+			err = client.storeStreamMode(src)
+		case ModeBlock:
+			err = client.storeBlockMode(src)
+		case ModeCompressed:
+			err = client.storeCompressedMode(src)
+		case ModeDeflate:
+			err = client.storeDeflateMode(src)
+		default:
+			err = ErrModeNotSupported
+		}
+	}
+	code, msg, replyErr := readResponse()
+	if replyErr != nil {
+		replyErr = replyError(code, msg, replyErr)
+	}
+	if code == cmd.StatusFileActionPending && err == nil && client.blockConfig.Repair {
+		replyErr = client.repairStore(localFile, msg)
 	}
-	if _, msg, err := client.ctrlConn.Reader.ReadResponse(cmd.StatusFileActionCompleted); err != nil {
-		return errors.New(msg)
+	// The data connection is not reused after a failure on either side.
+	if err != nil || replyErr != nil {
+		client.closeDataConn()
+	}
+	if replyErr != nil {
+		return replyErr
 	}
 
+	if err == nil {
+		err = client.verifyTransfer(local, remote)
+	}
 	return
 }
 
@@ -105,53 +160,122 @@ func (client *clientImpl) storeStreamMode(localFile io.Reader) error {
 }
 
 func (client *clientImpl) storeBlockMode(localFile io.Reader) (err error) {
-	if err := block.Send(client.dataConn, localFile); err != nil {
+	if err := client.blockConfig.Send(client.dataConn, localFile); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (client *clientImpl) Retrieve(local, remote string) (err error) {
+// As in block mode, the data connection is kept open after each file.
+func (client *clientImpl) storeCompressedMode(localFile io.Reader) error {
+	return compressed.Send(client.dataConn, localFile, compressed.Filler(client.type_))
+}
+
+// As in stream mode, the data connection is closed after each file.
+func (client *clientImpl) storeDeflateMode(localFile io.Reader) error {
+	w, err := zlib.NewWriterLevel(client.dataConn, client.deflateLevel)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, localFile); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.closeDataConn()
+}
+
+// Retrieves the remote file from offset, which is appended to the local file
+// truncated at offset if it is not 0.
+func (client *clientImpl) retrieve(local, remote string, offset int64) (err error) {
+	client.restartMarker = ""
 	p := path.Join(client.rootDir, local)
 	if err := os.MkdirAll(path.Dir(p), 0777); err != nil {
 		return err
 	}
 
-	localFile, err := os.Create(p)
+	localFile, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE, 0666)
 	if err != nil {
 		return err
 	}
 	defer localFile.Close()
 
+	if err := localFile.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := localFile.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
 	if err := client.createDataConn(); err != nil {
 		return err
 	}
 
-	if _, msg, err := client.cmd(cmd.ALREADY_OPEN, "RETR %s", remote); err != nil {
-		return errors.New(msg)
+	if err := client.restart(offset); err != nil {
+		return err
 	}
 
-	switch client.mode {
-	case ModeStream:
-		err = client.retrieveStreamMode(localFile)
-	case ModeBlock:
-		err = client.retrieveBlockMode(localFile)
-	default:
-		err = ErrModeNotSupported
+	if code, msg, err := client.cmd(cmd.ALREADY_OPEN, "RETR %s", remote); err != nil {
+		return replyError(code, msg, err)
+	}
+
+	dst := repr.NewWriter(client.countWriter(localFile), client.type_)
+	if client.stru == StruRecord {
+		err = client.retrieveRecords(record.NewLineWriter(dst, repr.Newline(client.type_)))
+	} else {
+		err = client.retrieveData(dst)
+	}
+	if err == nil {
+		err = dst.Close()
+	}
+	var restartErr *block.RestartError
+	if errors.As(err, &restartErr) {
+		client.restartMarker = restartErr.Marker
+	}
+	var damaged *block.DamagedError
+	if errors.As(err, &damaged) {
+		if code, msg, err := client.readTransferResponse(); err != nil {
+			return replyError(code, msg, err)
+		}
+		if err := client.repairRetrieve(localFile, damaged); err != nil {
+			return err
+		}
+		return client.verifyTransfer(local, remote)
 	}
 	if err != nil {
+		// The server replies to the transfer all the same, after it finds
+		// the data connection closed.
+		client.closeDataConn()
+		client.readTransferResponse()
 		return err
 	}
 
-	if _, msg, err := client.ctrlConn.Reader.ReadResponse(cmd.StatusFileActionCompleted); err != nil {
-		return errors.New(msg)
+	if code, msg, err := client.readTransferResponse(); err != nil {
+		return replyError(code, msg, err)
 	}
 
-	return
+	return client.verifyTransfer(local, remote)
 
 }
 
+// Receives the data of a RETR in file structure in the current mode.
+func (client *clientImpl) retrieveData(dst io.Writer) error {
+	switch client.mode {
+	case ModeStream:
+		return client.retrieveStreamMode(dst)
+	case ModeBlock:
+		return client.retrieveBlockMode(dst)
+	case ModeCompressed:
+		return client.retrieveCompressedMode(dst)
+	case ModeDeflate:
+		return client.retrieveDeflateMode(dst)
+	default:
+		return ErrModeNotSupported
+	}
+}
+
 func (client *clientImpl) retrieveStreamMode(localFile io.Writer) error {
 	defer client.closeDataConn() // In streaming mode, the data connection is closed after each file transfer.
 
@@ -163,9 +287,40 @@ func (client *clientImpl) retrieveStreamMode(localFile io.Writer) error {
 }
 
 func (client *clientImpl) retrieveBlockMode(localFile io.Writer) error {
-	if err := block.Receive(localFile, client.dataConn); err != nil {
+	if err := client.blockConfig.Receive(localFile, client.dataConn); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+func (client *clientImpl) retrieveCompressedMode(localFile io.Writer) error {
+	return compressed.Receive(localFile, client.dataConn, compressed.Filler(client.type_))
+}
+
+func (client *clientImpl) retrieveDeflateMode(localFile io.Writer) error {
+	defer client.closeDataConn()
+
+	r, err := zlib.NewReader(client.dataConn)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	_, err = io.Copy(localFile, r)
+	return err
+}
+
+// Size of the remote file in the current type, that is the number of bytes a
+// Retrieve would transfer.
+func (client *clientImpl) Size(remote string) (size int64, err error) {
+	err = client.retry(func() error {
+		code, msg, err := client.cmd(cmd.StatusFileStatus, "SIZE %s", remote)
+		if err != nil {
+			return replyError(code, msg, err)
+		}
+		size, err = strconv.ParseInt(strings.TrimSpace(msg), 10, 64)
+		return err
+	})
+	return
+}
@@ -2,9 +2,13 @@ package client
 
 import (
 	"bytes"
+	"compress/zlib"
 	"crypto/md5"
+	"encoding/binary"
 	"fmt"
 	"ftp/block"
+	"ftp/compressed"
+	"ftp/repr"
 	"io"
 	"net"
 	"net/textproto"
@@ -28,12 +32,15 @@ func TestRetrStreamMode(t *testing.T) {
 		var dataConn net.Conn
 
 		server.Writer.PrintfLine("220 Service ready for new user.")
+		answerFeat(server)
 		for {
 			if line, _ := server.ReadLine(); strings.HasPrefix(line, "PORT") {
 				var h1, h2, h3, h4, p1, p2 byte
 				fmt.Sscanf(line, "PORT %d,%d,%d,%d,%d,%d", &h1, &h2, &h3, &h4, &p1, &p2)
 				dataConn, _ = net.Dial("tcp", fmt.Sprintf("%d.%d.%d.%d:%d", h1, h2, h3, h4, int(p1)*256+int(p2)))
 				server.Writer.PrintfLine("200 Command okay.")
+			} else if strings.HasPrefix(line, "TYPE") {
+				server.Writer.PrintfLine("200 Command okay.")
 			} else if strings.HasPrefix(line, "RETR ") {
 				if dataConn == nil {
 					server.Writer.PrintfLine("150 File status okay; about to open data connection.")
@@ -50,6 +57,7 @@ func TestRetrStreamMode(t *testing.T) {
 	}()
 
 	client, _ := NewFtpClient("localhost:8970")
+	client.Type(TypeBinary)
 	if err := client.Retrieve("_test_/small9993", "small9993"); err != nil {
 		t.Fatal(err)
 	}
@@ -84,12 +92,15 @@ func TestStorStreamMode(t *testing.T) {
 		var dataConn net.Conn
 
 		server.Writer.PrintfLine("220 Service ready for new user.")
+		answerFeat(server)
 		for {
 			if line, _ := server.ReadLine(); strings.HasPrefix(line, "PORT") {
 				var h1, h2, h3, h4, p1, p2 byte
 				fmt.Sscanf(line, "PORT %d,%d,%d,%d,%d,%d", &h1, &h2, &h3, &h4, &p1, &p2)
 				dataConn, _ = net.Dial("tcp", fmt.Sprintf("%d.%d.%d.%d:%d", h1, h2, h3, h4, int(p1)*256+int(p2)))
 				server.Writer.PrintfLine("200 Command okay.")
+			} else if strings.HasPrefix(line, "TYPE") {
+				server.Writer.PrintfLine("200 Command okay.")
 			} else if strings.HasPrefix(line, "STOR ") {
 				if dataConn == nil {
 					server.Writer.PrintfLine("150 File status okay; about to open data connection.")
@@ -108,6 +119,7 @@ func TestStorStreamMode(t *testing.T) {
 	}()
 
 	client, _ := NewFtpClient("localhost:8971")
+	client.Type(TypeBinary)
 	if err := client.Store("test_files/small9993", "small9993"); err != nil {
 		t.Fatal(err)
 	}
@@ -146,12 +158,15 @@ func TestStorBlockMode(t *testing.T) {
 		var dataConn net.Conn
 
 		server.Writer.PrintfLine("220 Service ready for new user.")
+		answerFeat(server)
 		for {
 			if line, _ := server.ReadLine(); strings.HasPrefix(line, "PORT") {
 				var h1, h2, h3, h4, p1, p2 byte
 				fmt.Sscanf(line, "PORT %d,%d,%d,%d,%d,%d", &h1, &h2, &h3, &h4, &p1, &p2)
 				dataConn, _ = net.Dial("tcp", fmt.Sprintf("%d.%d.%d.%d:%d", h1, h2, h3, h4, int(p1)*256+int(p2)))
 				server.Writer.PrintfLine("200 Command okay.")
+			} else if strings.HasPrefix(line, "TYPE") {
+				server.Writer.PrintfLine("200 Command okay.")
 			} else if strings.HasPrefix(line, "STOR ") {
 				if dataConn == nil {
 					server.Writer.PrintfLine("150 File status okay; about to open data connection.")
@@ -170,6 +185,7 @@ func TestStorBlockMode(t *testing.T) {
 	}()
 
 	client, _ := NewFtpClient("localhost:8972")
+	client.Type(TypeBinary)
 	client.Mode(ModeBlock)
 	if err := client.Store("test_files/small9993", "small9993"); err != nil {
 		t.Fatal(err)
@@ -209,12 +225,15 @@ func TestRetrBlockMode(t *testing.T) {
 		var dataConn net.Conn
 
 		server.Writer.PrintfLine("220 Service ready for new user.")
+		answerFeat(server)
 		for {
 			if line, _ := server.ReadLine(); strings.HasPrefix(line, "PORT") {
 				var h1, h2, h3, h4, p1, p2 byte
 				fmt.Sscanf(line, "PORT %d,%d,%d,%d,%d,%d", &h1, &h2, &h3, &h4, &p1, &p2)
 				dataConn, _ = net.Dial("tcp", fmt.Sprintf("%d.%d.%d.%d:%d", h1, h2, h3, h4, int(p1)*256+int(p2)))
 				server.Writer.PrintfLine("200 Command okay.")
+			} else if strings.HasPrefix(line, "TYPE") {
+				server.Writer.PrintfLine("200 Command okay.")
 			} else if strings.HasPrefix(line, "RETR ") {
 				if dataConn == nil {
 					server.Writer.PrintfLine("150 File status okay; about to open data connection.")
@@ -233,6 +252,7 @@ func TestRetrBlockMode(t *testing.T) {
 	}()
 
 	client, _ := NewFtpClient("localhost:8973")
+	client.Type(TypeBinary)
 	client.Mode(ModeBlock)
 	if err := client.Retrieve("_test_/small9993", "small9993"); err != nil {
 		t.Fatal(err)
@@ -256,3 +276,315 @@ func TestRetrBlockMode(t *testing.T) {
 		t.Fatalf("file not equal \n%x\n%x", localMd5, remoteMd5)
 	}
 }
+
+func TestAsciiType(t *testing.T) {
+	os.Mkdir("_test_", 0777)
+	defer os.RemoveAll("_test_")
+	os.WriteFile("_test_/local.txt", []byte("x\ny\n"), 0666)
+
+	stored := make(chan []byte)
+	listener, _ := net.Listen("tcp", ":8974")
+	go func() {
+		conn, _ := listener.Accept()
+		listener.Close()
+		server := textproto.NewConn(conn)
+		defer server.Close()
+
+		var dataConn net.Conn
+
+		server.Writer.PrintfLine("220 Service ready for new user.")
+		answerFeat(server)
+		for {
+			line, err := server.ReadLine()
+			if err != nil {
+				return
+			}
+			if strings.HasPrefix(line, "PORT") {
+				var h1, h2, h3, h4, p1, p2 byte
+				fmt.Sscanf(line, "PORT %d,%d,%d,%d,%d,%d", &h1, &h2, &h3, &h4, &p1, &p2)
+				dataConn, _ = net.Dial("tcp", fmt.Sprintf("%d.%d.%d.%d:%d", h1, h2, h3, h4, int(p1)*256+int(p2)))
+				server.Writer.PrintfLine("200 Command okay.")
+			} else if strings.HasPrefix(line, "TYPE A") {
+				server.Writer.PrintfLine("200 Command okay.")
+			} else if strings.HasPrefix(line, "SIZE ") {
+				server.Writer.PrintfLine("213 6")
+			} else if strings.HasPrefix(line, "RETR ") {
+				server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+				dataConn.Write([]byte("a\r\nb\r\n"))
+				dataConn.Close()
+				server.Writer.PrintfLine("250 Requested file action okay, completed.")
+			} else if strings.HasPrefix(line, "STOR ") {
+				server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+				data, _ := io.ReadAll(dataConn)
+				dataConn.Close()
+				server.Writer.PrintfLine("250 Requested file action okay, completed.")
+				stored <- data
+			}
+		}
+	}()
+
+	client, err := NewFtpClient("localhost:8974")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Type(TypeAscii)
+
+	if size, err := client.Size("remote.txt"); err != nil || size != 6 {
+		t.Fatalf("size: %d %v", size, err)
+	}
+
+	repr.LocalNewline = []byte("\n")
+	if err := client.Retrieve("_test_/remote.txt", "remote.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile("_test_/remote.txt"); string(data) != "a\nb\n" {
+		t.Fatalf("CRLF not converted: %q", data)
+	}
+
+	go client.Store("_test_/local.txt", "local.txt")
+	if data := <-stored; string(data) != "x\r\ny\r\n" {
+		t.Fatalf("LF not converted: %q", data)
+	}
+}
+
+func TestCompressedMode(t *testing.T) {
+	os.Mkdir("_test_", 0777)
+	defer os.RemoveAll("_test_")
+
+	stored := make(chan []byte)
+	listener, _ := net.Listen("tcp", ":8976")
+	go func() {
+		conn, _ := listener.Accept()
+		listener.Close()
+		server := textproto.NewConn(conn)
+		defer server.Close()
+
+		var dataConn net.Conn
+
+		server.Writer.PrintfLine("220 Service ready for new user.")
+		answerFeat(server)
+		for {
+			line, err := server.ReadLine()
+			if err != nil {
+				return
+			}
+			if strings.HasPrefix(line, "PORT") {
+				var h1, h2, h3, h4, p1, p2 byte
+				fmt.Sscanf(line, "PORT %d,%d,%d,%d,%d,%d", &h1, &h2, &h3, &h4, &p1, &p2)
+				dataConn, _ = net.Dial("tcp", fmt.Sprintf("%d.%d.%d.%d:%d", h1, h2, h3, h4, int(p1)*256+int(p2)))
+				server.Writer.PrintfLine("200 Command okay.")
+			} else if strings.HasPrefix(line, "TYPE") || strings.HasPrefix(line, "MODE") {
+				server.Writer.PrintfLine("200 Command okay.")
+			} else if strings.HasPrefix(line, "RETR ") {
+				server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+				f, _ := os.Open(path.Join("test_files", line[len("RETR "):]))
+				compressed.Send(dataConn, f, 0)
+				f.Close()
+				server.Writer.PrintfLine("250 Requested file action okay, completed.")
+			} else if strings.HasPrefix(line, "STOR ") {
+				server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+				var data bytes.Buffer
+				compressed.Receive(&data, dataConn, 0)
+				server.Writer.PrintfLine("250 Requested file action okay, completed.")
+				stored <- data.Bytes()
+			}
+		}
+	}()
+
+	client, err := NewFtpClient("localhost:8976")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Type(TypeBinary)
+	if err := client.Mode(ModeCompressed); err != nil {
+		t.Fatal(err)
+	}
+
+	storeErr := make(chan error)
+	go func() { storeErr <- client.Store("test_files/small9993", "small9993") }()
+	local, _ := os.ReadFile("test_files/small9993")
+	if data := <-stored; !bytes.Equal(data, local) {
+		t.Fatal("stored file not equal")
+	}
+	if err := <-storeErr; err != nil {
+		t.Fatal(err)
+	}
+
+	// The same data connection is used again.
+	if err := client.Retrieve("_test_/small9993", "small9993"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile("_test_/small9993"); !bytes.Equal(data, local) {
+		t.Fatal("retrieved file not equal")
+	}
+}
+
+func TestDeflateMode(t *testing.T) {
+	os.Mkdir("_test_", 0777)
+	defer os.RemoveAll("_test_")
+
+	stored := make(chan []byte)
+	level := make(chan string, 1)
+	listener, _ := net.Listen("tcp", ":8977")
+	go func() {
+		conn, _ := listener.Accept()
+		listener.Close()
+		server := textproto.NewConn(conn)
+		defer server.Close()
+
+		var dataConn net.Conn
+
+		server.Writer.PrintfLine("220 Service ready for new user.")
+		answerFeat(server)
+		for {
+			line, err := server.ReadLine()
+			if err != nil {
+				return
+			}
+			if strings.HasPrefix(line, "PORT") {
+				var h1, h2, h3, h4, p1, p2 byte
+				fmt.Sscanf(line, "PORT %d,%d,%d,%d,%d,%d", &h1, &h2, &h3, &h4, &p1, &p2)
+				dataConn, _ = net.Dial("tcp", fmt.Sprintf("%d.%d.%d.%d:%d", h1, h2, h3, h4, int(p1)*256+int(p2)))
+				server.Writer.PrintfLine("200 Command okay.")
+			} else if strings.HasPrefix(line, "TYPE") || strings.HasPrefix(line, "MODE") {
+				server.Writer.PrintfLine("200 Command okay.")
+			} else if strings.HasPrefix(line, "OPTS") {
+				level <- line
+				server.Writer.PrintfLine("200 Command okay.")
+			} else if strings.HasPrefix(line, "RETR ") {
+				server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+				f, _ := os.Open(path.Join("test_files", line[len("RETR "):]))
+				w := zlib.NewWriter(dataConn)
+				io.Copy(w, f)
+				w.Close()
+				dataConn.Close()
+				f.Close()
+				server.Writer.PrintfLine("250 Requested file action okay, completed.")
+			} else if strings.HasPrefix(line, "STOR ") {
+				server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+				r, _ := zlib.NewReader(dataConn)
+				data, _ := io.ReadAll(r)
+				dataConn.Close()
+				server.Writer.PrintfLine("250 Requested file action okay, completed.")
+				stored <- data
+			}
+		}
+	}()
+
+	client, err := NewFtpClient("localhost:8977")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Type(TypeBinary)
+	if err := client.Mode(ModeDeflate); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetDeflateLevel(10); err != ErrDeflateLevel {
+		t.Fatal("level 10 should be rejected")
+	}
+	if err := client.SetDeflateLevel(9); err != nil {
+		t.Fatal(err)
+	}
+	if line := <-level; line != "OPTS MODE Z LEVEL 9" {
+		t.Fatalf("got %q", line)
+	}
+
+	storeErr := make(chan error)
+	go func() { storeErr <- client.Store("test_files/small9993", "small9993") }()
+	local, _ := os.ReadFile("test_files/small9993")
+	if data := <-stored; !bytes.Equal(data, local) {
+		t.Fatal("stored file not equal")
+	}
+	if err := <-storeErr; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Retrieve("_test_/small9993", "small9993"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile("_test_/small9993"); !bytes.Equal(data, local) {
+		t.Fatal("retrieved file not equal")
+	}
+}
+
+func TestBlockChecksum(t *testing.T) {
+	lines := make(chan string, 8)
+	stored := make(chan []byte)
+	listener, _ := net.Listen("tcp", ":8979")
+	go func() {
+		conn, _ := listener.Accept()
+		listener.Close()
+		server := textproto.NewConn(conn)
+		defer server.Close()
+
+		var dataConn net.Conn
+
+		server.Writer.PrintfLine("220 Service ready for new user.")
+		answerFeat(server)
+		for {
+			line, err := server.ReadLine()
+			if err != nil {
+				return
+			}
+			if strings.HasPrefix(line, "PORT") {
+				var h1, h2, h3, h4, p1, p2 byte
+				fmt.Sscanf(line, "PORT %d,%d,%d,%d,%d,%d", &h1, &h2, &h3, &h4, &p1, &p2)
+				dataConn, _ = net.Dial("tcp", fmt.Sprintf("%d.%d.%d.%d:%d", h1, h2, h3, h4, int(p1)*256+int(p2)))
+				server.Writer.PrintfLine("200 Command okay.")
+			} else if strings.HasPrefix(line, "STOR ") {
+				server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+				// The algorithm is in the header, after the block size.
+				hdr := make([]byte, 9)
+				io.ReadFull(dataConn, hdr)
+				lines <- fmt.Sprint(binary.BigEndian.Uint64(hdr), block.Hash(hdr[8]))
+				var data bytes.Buffer
+				block.Config{Checksum: true}.Receive(&data, io.MultiReader(bytes.NewReader(hdr), dataConn))
+				server.Writer.PrintfLine("250 Requested file action okay, completed.")
+				stored <- data.Bytes()
+			} else {
+				lines <- line
+				server.Writer.PrintfLine("200 Command okay.")
+			}
+		}
+	}()
+
+	client, err := NewFtpClient("localhost:8979")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Type(TypeBinary)
+	client.Mode(ModeBlock)
+	if err := client.SetBlockChecksum(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetBlockHash("MD4"); err != block.ErrUnknownHash {
+		t.Fatalf("got %v", err)
+	}
+	if err := client.SetBlockHash("xxh64"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetBlockSize(0); err != block.ErrBlockSize {
+		t.Fatalf("got %v", err)
+	}
+	if err := client.SetBlockSize(512); err != nil {
+		t.Fatal(err)
+	}
+	for _, expect := range []string{"TYPE I", "MODE B", "OPTS MODE B FRAMING CHECKSUM", "OPTS MODE B HASH XXH64", "OPTS MODE B BLOCKSIZE 512"} {
+		if line := <-lines; line != expect {
+			t.Fatalf("got %q, want %q", line, expect)
+		}
+	}
+
+	storeErr := make(chan error)
+	go func() { storeErr <- client.Store("test_files/small9993", "small9993") }()
+	if line := <-lines; line != "512 XXH64" {
+		t.Fatalf("sent in %s", line)
+	}
+	local, _ := os.ReadFile("test_files/small9993")
+	if data := <-stored; !bytes.Equal(data, local) {
+		t.Fatal("stored file not equal")
+	}
+	if err := <-storeErr; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,139 @@
+package client
+
+import (
+	"encoding/hex"
+	"errors"
+	"ftp/cmd"
+	"ftp/filehash"
+	"ftp/repr"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+const DefaultHashAlgorithm = filehash.Default
+
+var (
+	ErrHashNotSupported = errors.New("hash not support")
+	ErrInvalidHashReply = errors.New("invalid hash reply")
+	ErrVerifyFailed     = errors.New("local and remote files differ")
+)
+
+// Selects the algorithm of Checksum, SHA-1, SHA-256, SHA-512, MD5 or CRC32.
+// A server which lists its features has to list the algorithm for HASH, or
+// the command of the algorithm, which needs no OPTS.
+func (client *clientImpl) SetHashAlgorithm(algorithm string) error {
+	algorithm = strings.ToUpper(algorithm)
+	if filehash.New(algorithm) == nil {
+		return ErrHashNotSupported
+	}
+	if client.features != nil && !client.advertised("HASH", "") {
+		if !client.advertised(filehash.Commands[algorithm], "") {
+			return ErrHashNotSupported
+		}
+		client.hashAlgorithm = algorithm
+		return nil
+	}
+	if client.features != nil && !client.hashAdvertised(algorithm) {
+		return ErrHashNotSupported
+	}
+
+	if code, msg, err := client.cmd(cmd.OK, "OPTS HASH %s", algorithm); err != nil {
+		return replyError(code, msg, err)
+	}
+
+	client.hashAlgorithm = algorithm
+	return nil
+}
+
+func (client *clientImpl) SetVerify(enabled bool) {
+	client.verify = enabled
+}
+
+// The digest of the remote file in hex, as it would be retrieved in the
+// current type.
+func (client *clientImpl) Checksum(remote string) (digest string, err error) {
+	err = client.retry(func() error {
+		_, digest, err = client.remoteDigest(remote)
+		return err
+	})
+	return
+}
+
+// Compares the digests of the local and the remote file, both as they would
+// be transferred in the current type.
+func (client *clientImpl) Verify(local, remote string) error {
+	return client.retry(func() error {
+		return client.verifyFile(local, remote)
+	})
+}
+
+func (client *clientImpl) verifyFile(local, remote string) error {
+	algorithm, digest, err := client.remoteDigest(remote)
+	if err != nil {
+		return err
+	}
+	h := filehash.New(algorithm)
+	if h == nil {
+		return ErrHashNotSupported
+	}
+
+	localFile, err := os.Open(path.Join(client.rootDir, local))
+	if err != nil {
+		return err
+	}
+	defer localFile.Close()
+
+	if _, err := io.Copy(h, repr.NewReader(localFile, client.type_)); err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != digest {
+		return ErrVerifyFailed
+	}
+	return nil
+}
+
+// Verifies a transfer with SetVerify. In record structure the last record
+// may gain an end of line, so the files are not compared.
+func (client *clientImpl) verifyTransfer(local, remote string) error {
+	if !client.verify || client.stru != StruFile {
+		return nil
+	}
+	return client.verifyFile(local, remote)
+}
+
+// Returns the algorithm and the digest of the remote file by HASH, or by the
+// command of the algorithm if the server does not implement HASH. When the
+// features of the server are known only the command it lists is sent.
+func (client *clientImpl) remoteDigest(remote string) (string, string, error) {
+	if client.features != nil && !client.advertised("HASH", "") {
+		return client.xDigest(remote)
+	}
+
+	code, msg, err := client.cmd(cmd.StatusFileStatus, "HASH %s", remote)
+	if err == nil {
+		// <algorithm> <start>-<end> <digest> <pathname>
+		fields := strings.SplitN(msg, " ", 4)
+		if len(fields) < 3 {
+			return "", "", ErrInvalidHashReply
+		}
+		return strings.ToUpper(fields[0]), strings.ToLower(fields[2]), nil
+	}
+	if code != cmd.SYNTAX_ERROR && code != cmd.StatusNotImplemented {
+		return "", "", replyError(code, msg, err)
+	}
+	return client.xDigest(remote)
+}
+
+func (client *clientImpl) xDigest(remote string) (string, string, error) {
+	command, has := filehash.Commands[client.hashAlgorithm]
+	if !has || !client.mayHave(command, "") {
+		return "", "", ErrHashNotSupported
+	}
+	code, msg, err := client.cmd(cmd.StatusFileActionCompleted, "%s %s", command, remote)
+	if err != nil {
+		return "", "", replyError(code, msg, err)
+	}
+	return client.hashAlgorithm, strings.ToLower(strings.TrimSpace(msg)), nil
+}
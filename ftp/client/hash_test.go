@@ -0,0 +1,109 @@
+package client
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	local, _ := os.ReadFile("test_files/small9993")
+	listener, _ := net.Listen("tcp", ":8982")
+	go func() {
+		conn, _ := listener.Accept()
+		listener.Close()
+		server := textproto.NewConn(conn)
+		defer server.Close()
+
+		var dataConn net.Conn
+		files := make(map[string][]byte)
+		algorithm := "SHA-256"
+		digest := func(data []byte) string {
+			if algorithm == "MD5" {
+				sum := md5.Sum(data)
+				return hex.EncodeToString(sum[:])
+			}
+			sum := sha256.Sum256(data)
+			return hex.EncodeToString(sum[:])
+		}
+
+		server.Writer.PrintfLine("220 Service ready for new user.")
+		for {
+			line, err := server.ReadLine()
+			if err != nil {
+				return
+			}
+			command := strings.SplitN(line, " ", 2)
+			switch command[0] {
+			case "PORT":
+				var h1, h2, h3, h4, p1, p2 byte
+				fmt.Sscanf(line, "PORT %d,%d,%d,%d,%d,%d", &h1, &h2, &h3, &h4, &p1, &p2)
+				dataConn, _ = net.Dial("tcp", fmt.Sprintf("%d.%d.%d.%d:%d", h1, h2, h3, h4, int(p1)*256+int(p2)))
+				server.Writer.PrintfLine("200 Command okay.")
+			case "STOR":
+				server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+				data, _ := io.ReadAll(dataConn)
+				// A byte is lost on the way.
+				if command[1] == "broken" {
+					data = data[1:]
+				}
+				files[command[1]] = data
+				server.Writer.PrintfLine("250 Requested file action okay, completed.")
+			case "OPTS":
+				algorithm = strings.TrimPrefix(command[1], "HASH ")
+				server.Writer.PrintfLine("200 %s", algorithm)
+			case "HASH":
+				// As a server which does not implement HASH.
+				if command[1] == "legacy" {
+					server.Writer.PrintfLine("500 Syntax error, command unrecognized.")
+					break
+				}
+				data := files[command[1]]
+				server.Writer.PrintfLine("213 %s 0-%d %s %s", algorithm, len(data), digest(data), command[1])
+			case "XMD5":
+				server.Writer.PrintfLine("250 %s", strings.ToUpper(digest(files["small9993"])))
+			default:
+				server.Writer.PrintfLine("200 Command okay.")
+			}
+		}
+	}()
+
+	client, err := NewFtpClient("localhost:8982")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Type(TypeBinary)
+	client.SetVerify(true)
+
+	if err := client.Store("test_files/small9993", "small9993"); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(local)
+	if digest, err := client.Checksum("small9993"); err != nil || digest != hex.EncodeToString(sum[:]) {
+		t.Fatalf("got %s, %v", digest, err)
+	}
+	if err := client.Store("test_files/small9993", "broken"); err != ErrVerifyFailed {
+		t.Fatalf("got %v", err)
+	}
+
+	if err := client.SetHashAlgorithm("SHA-3"); err != ErrHashNotSupported {
+		t.Fatalf("got %v", err)
+	}
+	if err := client.SetHashAlgorithm("md5"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Verify("test_files/small9993", "small9993"); err != nil {
+		t.Fatal(err)
+	}
+	// Falls back to XMD5.
+	if err := client.Verify("test_files/small9993", "legacy"); err != nil {
+		t.Fatal(err)
+	}
+}
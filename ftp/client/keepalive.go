@@ -0,0 +1,110 @@
+package client
+
+import (
+	"ftp/cmd"
+	"time"
+)
+
+func (client *clientImpl) Noop() error {
+	if code, msg, err := client.cmd(cmd.OK, "NOOP"); err != nil {
+		return replyError(code, msg, err)
+	}
+	return nil
+}
+
+// The round trip time of a NOOP in milliseconds, which checks that the
+// session is alive.
+func (client *clientImpl) Ping() (int64, error) {
+	start := time.Now()
+	if err := client.Noop(); err != nil {
+		return 0, err
+	}
+	return time.Since(start).Milliseconds(), nil
+}
+
+// The shortest interval of the keepalive, a shorter one is raised to it.
+var minKeepaliveInterval = time.Second
+
+// Sends NOOP whenever the control connection has been idle for the given
+// milliseconds, so that NAT devices on the way do not drop it. In a transfer the server reads
+// the NOOPs only after it, and their replies are read after the reply of the
+// transfer. 0 stops the keepalive, which also stops at Logout or when the
+// connection fails.
+func (client *clientImpl) SetKeepalive(milliseconds int) {
+	interval := time.Duration(milliseconds) * time.Millisecond
+	client.ctrl.Lock()
+	defer client.ctrl.Unlock()
+
+	if client.ctrl.keepalive != nil {
+		close(client.ctrl.keepalive)
+		client.ctrl.keepalive = nil
+	}
+	if interval <= 0 {
+		return
+	}
+	if interval < minKeepaliveInterval {
+		interval = minKeepaliveInterval
+	}
+
+	stop := make(chan struct{})
+	client.ctrl.keepalive = stop
+	go func() {
+		ticker := time.NewTicker(interval / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := client.keepaliveNoop(interval); err != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+func (client *clientImpl) keepaliveNoop(interval time.Duration) error {
+	client.ctrl.Lock()
+	defer client.ctrl.Unlock()
+
+	if time.Since(client.ctrl.lastCmd) < interval || (client.ctrl.busy > 0 && !client.ctrl.transferring) {
+		return nil
+	}
+	client.ctrl.lastCmd = time.Now()
+	if client.ctrl.transferring {
+		client.ctrl.noops++
+		return client.ctrlConn.PrintfLine("NOOP")
+	}
+	if _, err := client.ctrlConn.Cmd("NOOP"); err != nil {
+		return err
+	}
+	_, _, err := client.ctrlConn.ReadResponse(cmd.OK)
+	return err
+}
+
+// Called after the reply of a transfer, reads the replies of the NOOPs sent
+// in it.
+func (client *clientImpl) endTransfer() {
+	client.ctrl.Lock()
+	defer client.ctrl.Unlock()
+
+	client.ctrl.transferring = false
+	for ; client.ctrl.noops > 0; client.ctrl.noops-- {
+		client.ctrlConn.ReadResponse(cmd.OK)
+	}
+}
+
+// Holds off the keepalive while the commands and the replies are not in step,
+// as in a pipelined batch.
+func (client *clientImpl) holdKeepalive() func() {
+	client.ctrl.Lock()
+	client.ctrl.busy++
+	client.ctrl.Unlock()
+
+	return func() {
+		client.ctrl.Lock()
+		client.ctrl.busy--
+		client.ctrl.Unlock()
+	}
+}
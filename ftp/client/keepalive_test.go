@@ -0,0 +1,94 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeepalive(t *testing.T) {
+	os.Mkdir("_test_", 0777)
+	defer os.RemoveAll("_test_")
+	defer func(interval time.Duration) { minKeepaliveInterval = interval }(minKeepaliveInterval)
+	minKeepaliveInterval = 10 * time.Millisecond
+
+	noops := make(chan bool, 64) // whether the NOOP came in a transfer
+	listener, _ := net.Listen("tcp", ":8988")
+	go func() {
+		conn, _ := listener.Accept()
+		listener.Close()
+		server := textproto.NewConn(conn)
+		defer server.Close()
+
+		var dataConn net.Conn
+		transferred := false
+		server.Writer.PrintfLine("220 Service ready for new user.")
+		for {
+			line, err := server.ReadLine()
+			if err != nil {
+				return
+			}
+			command := strings.SplitN(line, " ", 2)
+			switch command[0] {
+			case "PORT":
+				var h1, h2, h3, h4, p1, p2 byte
+				fmt.Sscanf(line, "PORT %d,%d,%d,%d,%d,%d", &h1, &h2, &h3, &h4, &p1, &p2)
+				dataConn, _ = net.Dial("tcp", fmt.Sprintf("%d.%d.%d.%d:%d", h1, h2, h3, h4, int(p1)*256+int(p2)))
+				server.Writer.PrintfLine("200 Command okay.")
+			case "NOOP":
+				noops <- transferred
+				transferred = false
+				server.Writer.PrintfLine("200 Command okay.")
+			case "RETR":
+				// A slow transfer, the commands sent in it are read after it.
+				server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+				time.Sleep(200 * time.Millisecond)
+				dataConn.Write([]byte("slow"))
+				dataConn.Close()
+				transferred = true
+				server.Writer.PrintfLine("250 Requested file action okay, completed.")
+			case "SIZE":
+				server.Writer.PrintfLine("213 4")
+			default:
+				server.Writer.PrintfLine("200 Command okay.")
+			}
+		}
+	}()
+
+	client, err := NewFtpClient("localhost:8988")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rtt, err := client.Ping(); err != nil || rtt < 0 {
+		t.Fatalf("got %v, %v", rtt, err)
+	}
+	<-noops
+
+	// A too short interval is raised, rather than ticking at 0.
+	client.SetKeepalive(3)
+	client.SetKeepalive(40)
+	if err := client.Retrieve("_test_/slow", "slow"); err != nil {
+		t.Fatal(err)
+	}
+	// The replies of the NOOPs in the transfer are read after it.
+	if size, err := client.Size("slow"); err != nil || size != 4 {
+		t.Fatalf("got %d, %v", size, err)
+	}
+	if len(noops) == 0 || !<-noops {
+		t.Fatal("no NOOP in the transfer")
+	}
+
+	// An idle session is kept alive.
+	for len(noops) > 0 {
+		<-noops
+	}
+	time.Sleep(100 * time.Millisecond)
+	if len(noops) == 0 {
+		t.Fatal("no NOOP while idle")
+	}
+	client.SetKeepalive(0)
+}
@@ -0,0 +1,248 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sync"
+)
+
+var (
+	ErrManagerSessions = errors.New("a manager needs at least one session")
+	ErrManagerClosed   = errors.New("transfer manager closed")
+)
+
+// Receives the progress of the transfers of a Manager, summed over all its
+// sessions. The bytes are of the local files, the total bytes of a Retrieve
+// are the sizes of the remote files as SIZE gives them. The bytes a failed
+// attempt has counted are taken back before the file is retried.
+type ProgressListener interface {
+	OnProgress(files, totalFiles int, bytes, totalBytes int64)
+}
+
+// A Manager transfers the files of a batch concurrently over a pool of
+// sessions, at most one file per session at a time. The sessions are opened
+// like the client the Manager is created from, logged in as the same user
+// and with the same transfer parameters.
+type Manager struct {
+	sessions []*clientImpl
+	listener ProgressListener
+
+	mu                sync.Mutex // guards the progress
+	files, totalFiles int
+	bytes, totalBytes int64
+	fileBytes         map[*clientImpl]int64 // of the file each session transfers
+}
+
+// Opens a Manager of n sessions to the server of client.
+func NewManager(client FtpClient, n int) (*Manager, error) {
+	template, ok := client.(*clientImpl)
+	if !ok || n < 1 {
+		return nil, ErrManagerSessions
+	}
+
+	m := &Manager{fileBytes: make(map[*clientImpl]int64)}
+	for i := 0; i < n; i++ {
+		session, err := template.newSession()
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		session.onTransfer = func(n int64) { m.transferred(session, n) }
+		session.onAttempt = func(offset int64) { m.attempt(session, offset) }
+		m.sessions = append(m.sessions, session)
+	}
+	return m, nil
+}
+
+func (m *Manager) SetProgressListener(listener ProgressListener) {
+	m.listener = listener
+}
+
+// Logs out and closes all the sessions.
+func (m *Manager) Close() {
+	for _, session := range m.sessions {
+		session.close()
+	}
+	m.sessions = nil
+}
+
+// Stores the files of the local directory and its subdirectories under the
+// remote directory, as FtpClient.StoreDir does over one session.
+func (m *Manager) StoreDir(localdir, remotedir string) error {
+	if len(m.sessions) == 0 {
+		return ErrManagerClosed
+	}
+
+	batch := NewBatch()
+	if err := addDir(batch, m.sessions[0].rootDir, localdir, remotedir); err != nil {
+		return err
+	}
+	return m.Store(batch)
+}
+
+func addDir(batch *Batch, rootDir, localdir, remotedir string) error {
+	files, err := os.ReadDir(path.Join(rootDir, localdir))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		local, remote := path.Join(localdir, file.Name()), path.Join(remotedir, file.Name())
+		if file.IsDir() {
+			if err := addDir(batch, rootDir, local, remote); err != nil {
+				return err
+			}
+		} else {
+			batch.Add(local, remote)
+		}
+	}
+	return nil
+}
+
+// Stores the files of the batch. The result of each file is kept in the
+// batch, and the error returned is that of the first file failed in the
+// order of the batch, whichever session failed first.
+func (m *Manager) Store(batch *Batch) error {
+	if len(m.sessions) == 0 {
+		return ErrManagerClosed
+	}
+
+	var total int64
+	for _, file := range batch.files {
+		if fs, err := os.Stat(path.Join(m.sessions[0].rootDir, file.local)); err == nil {
+			total += fs.Size()
+		}
+	}
+	return m.run(batch, total, (*clientImpl).StoreFile)
+}
+
+// Retrieves the files of the batch, with the results as in Store.
+func (m *Manager) Retrieve(batch *Batch) error {
+	if len(m.sessions) == 0 {
+		return ErrManagerClosed
+	}
+
+	var total int64
+	for _, file := range batch.files {
+		if size, err := m.sessions[0].Size(file.remote); err == nil {
+			total += size
+		}
+	}
+	return m.run(batch, total, (*clientImpl).Retrieve)
+}
+
+func (m *Manager) run(batch *Batch, totalBytes int64, transfer func(session *clientImpl, local, remote string) error) error {
+	if len(m.sessions) == 0 {
+		return ErrManagerClosed
+	}
+
+	m.mu.Lock()
+	m.files, m.totalFiles = 0, len(batch.files)
+	m.bytes, m.totalBytes = 0, totalBytes
+	m.mu.Unlock()
+
+	files := make(chan int)
+	var wg sync.WaitGroup
+	for _, session := range m.sessions {
+		wg.Add(1)
+		go func(session *clientImpl) {
+			defer wg.Done()
+			for i := range files {
+				file := &batch.files[i]
+				file.err = transfer(session, file.local, file.remote)
+				m.fileDone(session)
+			}
+		}(session)
+	}
+	for i := range batch.files {
+		files <- i
+	}
+	close(files)
+	wg.Wait()
+
+	for _, file := range batch.files {
+		if file.err != nil {
+			return file.err
+		}
+	}
+	return nil
+}
+
+func (m *Manager) transferred(session *clientImpl, n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fileBytes[session] += n
+	m.bytes += n
+	m.notify()
+}
+
+// An attempt of the file resumes at offset, the bytes counted past it are
+// transferred again.
+func (m *Manager) attempt(session *clientImpl, offset int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if back := m.fileBytes[session] - offset; back > 0 {
+		m.fileBytes[session] = offset
+		m.bytes -= back
+		m.notify()
+	}
+}
+
+func (m *Manager) fileDone(session *clientImpl) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.fileBytes, session)
+	m.files++
+	m.notify()
+}
+
+// Called with mu held, so the listener sees the progress in order.
+func (m *Manager) notify() {
+	if m.listener != nil {
+		m.listener.OnProgress(m.files, m.totalFiles, m.bytes, m.totalBytes)
+	}
+}
+
+// Counts the bytes read from the local file by onTransfer, if it is set.
+func (client *clientImpl) countReader(r io.Reader) io.Reader {
+	if client.onTransfer == nil {
+		return r
+	}
+	return &countingReader{r: r, count: client.onTransfer}
+}
+
+// Counts the bytes written to the local file by onTransfer, if it is set.
+func (client *clientImpl) countWriter(w io.Writer) io.Writer {
+	if client.onTransfer == nil {
+		return w
+	}
+	return &countingWriter{w: w, count: client.onTransfer}
+}
+
+type countingReader struct {
+	r     io.Reader
+	count func(n int64)
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.count(int64(n))
+	}
+	return n, err
+}
+
+type countingWriter struct {
+	w     io.Writer
+	count func(n int64)
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if n > 0 {
+		w.count(int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,196 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type progressRecorder struct {
+	files, totalFiles int
+	bytes, totalBytes int64
+	retried           bool // the bytes went back for a retry
+}
+
+func (p *progressRecorder) OnProgress(files, totalFiles int, bytes, totalBytes int64) {
+	if files < p.files || bytes > totalBytes {
+		panic("progress goes back or past the total")
+	}
+	if bytes < p.bytes {
+		p.retried = true
+	}
+	p.files, p.totalFiles, p.bytes, p.totalBytes = files, totalFiles, bytes, totalBytes
+}
+
+func TestManager(t *testing.T) {
+	os.Mkdir("_test_", 0777)
+	defer os.RemoveAll("_test_")
+
+	var mu sync.Mutex
+	files := make(map[string][]byte)
+	sessions := 0
+	flaky := true
+
+	listener, _ := net.Listen("tcp", ":8985")
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			sessions++
+			mu.Unlock()
+			go func() {
+				server := textproto.NewConn(conn)
+				defer server.Close()
+
+				var dataConn net.Conn
+				server.Writer.PrintfLine("220 Service ready for new user.")
+				for {
+					line, err := server.ReadLine()
+					if err != nil {
+						return
+					}
+					command := strings.SplitN(line, " ", 2)
+					switch command[0] {
+					case "USER":
+						server.Writer.PrintfLine("331 User name okay, need password.")
+					case "PASS":
+						server.Writer.PrintfLine("230 User logged in, proceed.")
+					case "PORT":
+						var h1, h2, h3, h4, p1, p2 byte
+						fmt.Sscanf(line, "PORT %d,%d,%d,%d,%d,%d", &h1, &h2, &h3, &h4, &p1, &p2)
+						dataConn, _ = net.Dial("tcp", fmt.Sprintf("%d.%d.%d.%d:%d", h1, h2, h3, h4, int(p1)*256+int(p2)))
+						server.Writer.PrintfLine("200 Command okay.")
+					case "SIZE":
+						mu.Lock()
+						data, has := files[command[1]]
+						mu.Unlock()
+						if !has {
+							server.Writer.PrintfLine("550 File unavailable.")
+							break
+						}
+						server.Writer.PrintfLine("213 %d", len(data))
+					case "STOR":
+						server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+						data, _ := io.ReadAll(dataConn)
+						mu.Lock()
+						// The first upload of flaky fails once it is read.
+						failed := command[1] == "flaky" && flaky
+						if failed {
+							flaky = false
+						} else {
+							files[command[1]] = data
+						}
+						mu.Unlock()
+						if failed {
+							server.Writer.PrintfLine("451 Requested action aborted: local error in processing.")
+							break
+						}
+						server.Writer.PrintfLine("250 Requested file action okay, completed.")
+					case "RETR":
+						mu.Lock()
+						data, has := files[command[1]]
+						mu.Unlock()
+						if !has {
+							dataConn.Close()
+							server.Writer.PrintfLine("550 File unavailable.")
+							break
+						}
+						server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+						dataConn.Write(data)
+						dataConn.Close()
+						server.Writer.PrintfLine("250 Requested file action okay, completed.")
+					case "QUIT":
+						server.Writer.PrintfLine("221 Service closing control connection.")
+						return
+					default:
+						server.Writer.PrintfLine("200 Command okay.")
+					}
+				}
+			}()
+		}
+	}()
+
+	client, err := NewFtpClient("localhost:8985")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Login("user", "password")
+	client.Type(TypeBinary)
+	client.SetRetryPolicy(1, 1)
+
+	m, err := NewManager(client, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	var progress progressRecorder
+	m.SetProgressListener(&progress)
+
+	if err := m.StoreDir("test_files", "dir"); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"small.txt", "small2.txt", "small9993"}
+	var total int64
+	for _, name := range names {
+		local, _ := os.ReadFile("test_files/" + name)
+		if !bytes.Equal(files["dir/"+name], local) {
+			t.Fatalf("%s not equal", name)
+		}
+		total += int64(len(local))
+	}
+	if progress != (progressRecorder{3, 3, total, total, false}) {
+		t.Fatalf("got progress %+v", progress)
+	}
+
+	// The error is of the first file failed in the order of the batch.
+	progress = progressRecorder{}
+	batch := NewBatch()
+	for _, name := range names {
+		batch.Add("_test_/"+name, "dir/"+name)
+	}
+	batch.Add("_test_/none", "none")
+	batch.Add("_test_/none2", "none2")
+	if err := m.Retrieve(batch); err == nil || err != batch.Err(3) {
+		t.Fatalf("got %v", err)
+	}
+	for i, name := range names {
+		if err := batch.Err(i); err != nil {
+			t.Fatal(err)
+		}
+		local, _ := os.ReadFile("test_files/" + name)
+		if data, _ := os.ReadFile("_test_/" + name); !bytes.Equal(data, local) {
+			t.Fatalf("%s not equal", name)
+		}
+	}
+	// The total of a retrieve is summed by SIZE.
+	if batch.Err(4) == nil || progress.files != 5 || progress.bytes != total || progress.totalBytes != total {
+		t.Fatalf("got %v, progress %+v", batch.Err(4), progress)
+	}
+
+	// The bytes of a failed attempt are not counted twice.
+	progress = progressRecorder{}
+	batch = NewBatch()
+	batch.Add("test_files/small9993", "flaky")
+	if err := m.Store(batch); err != nil {
+		t.Fatal(err)
+	}
+	flakySize := int64(len(files["dir/small9993"]))
+	if progress != (progressRecorder{1, 1, flakySize, flakySize, true}) {
+		t.Fatalf("got progress %+v", progress)
+	}
+
+	// The sessions of the manager and the client itself.
+	if sessions != 3 {
+		t.Fatalf("%d sessions", sessions)
+	}
+}
@@ -0,0 +1,282 @@
+package client
+
+import (
+	"errors"
+	"ftp/cmd"
+	"ftp/filehash"
+	"io"
+	"os"
+	"path"
+	"sync"
+)
+
+const DefaultParallelStreams = 4
+
+// A range smaller than this is not worth a session of its own.
+var minRangeSize int64 = 1 << 20
+
+// A failed range is retrieved again on its session up to this many times.
+const rangeRetries = 2
+
+var ErrRangeNotSupported = errors.New("range not supported")
+
+// Sets the number of sessions of RetrieveParallel, this one included.
+func (client *clientImpl) SetParallelStreams(n int) {
+	if n < 1 {
+		n = 1
+	}
+	client.parallelStreams = n
+}
+
+// Retrieves the remote file in as many ranges as there are streams, each over
+// a session of its own opened like this one, and writes each range in place
+// in the local file. The ranges are restricted by RANG, as in
+// draft-bryan-ftp-range, so they need a binary type and file structure. A
+// file which can not be split, or a server without RANG, is retrieved by
+// Retrieve. A range which fails is retrieved again on its session, and the
+// file put together is checked against the remote one, see verifyRanges.
+func (client *clientImpl) RetrieveParallel(local, remote string) error {
+	if !client.binary() || client.stru != StruFile || !client.mayHave("RANG", "STREAM") {
+		return client.Retrieve(local, remote)
+	}
+
+	size, err := client.Size(remote)
+	if err != nil {
+		return err
+	}
+	streams := int64(client.parallelStreams)
+	if n := (size + minRangeSize - 1) / minRangeSize; n < streams {
+		streams = n
+	}
+	if streams <= 1 {
+		return client.Retrieve(local, remote)
+	}
+
+	// The sessions which can not be opened leave fewer streams.
+	sessions := []*clientImpl{client}
+	defer func() {
+		for _, session := range sessions[1:] {
+			session.close()
+		}
+	}()
+	for i := int64(1); i < streams; i++ {
+		session, err := client.newSession()
+		if err != nil {
+			break
+		}
+		sessions = append(sessions, session)
+	}
+
+	p := path.Join(client.rootDir, local)
+	if err := os.MkdirAll(path.Dir(p), 0777); err != nil {
+		return err
+	}
+	localFile, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	defer localFile.Close()
+	if err := localFile.Truncate(size); err != nil {
+		return err
+	}
+
+	n := int64(len(sessions))
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i, session := range sessions {
+		start, end := size*int64(i)/n, size*int64(i+1)/n
+		wg.Add(1)
+		go func(i int, session *clientImpl) {
+			defer wg.Done()
+			errs[i] = session.retryRange(localFile, remote, start, end)
+		}(i, session)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err == ErrRangeNotSupported {
+			localFile.Close()
+			return client.Retrieve(local, remote)
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return client.verifyRanges(local, remote, size)
+}
+
+// Retrieves the range, and again after a failure up to rangeRetries times, on
+// a new connection if the session has lost its own. A permanent reply is not
+// retried.
+func (client *clientImpl) retryRange(localFile io.WriterAt, remote string, start, end int64) error {
+	err := client.retrieveRange(localFile, remote, start, end)
+	for i := 0; i < rangeRetries && err != nil && err != ErrRangeNotSupported; i++ {
+		var reply *ReplyError
+		if errors.As(err, &reply) && !reply.Transient() {
+			return err
+		}
+		if connectionLost(err) {
+			if err = client.Reconnect(); err != nil {
+				continue
+			}
+		}
+		err = client.retrieveRange(localFile, remote, start, end)
+	}
+	return err
+}
+
+// Checks the file put together from the ranges: its size against SIZE of the
+// remote file, which may have changed meanwhile, and its digest when the
+// server lists HASH or the command of the algorithm, or with SetVerify.
+func (client *clientImpl) verifyRanges(local, remote string, size int64) error {
+	fs, err := os.Stat(path.Join(client.rootDir, local))
+	if err != nil {
+		return err
+	}
+	remoteSize, err := client.Size(remote)
+	if err != nil {
+		return err
+	}
+	if fs.Size() != size || remoteSize != size {
+		return ErrVerifyFailed
+	}
+
+	if client.verify || client.advertised("HASH", "") ||
+		client.advertised(filehash.Commands[client.hashAlgorithm], "") {
+		return client.verifyFile(local, remote)
+	}
+	return nil
+}
+
+// Retrieves the bytes of the remote file from start to end, end excluded, to
+// the same offsets in localFile.
+func (client *clientImpl) retrieveRange(localFile io.WriterAt, remote string, start, end int64) error {
+	if err := client.createDataConn(); err != nil {
+		return err
+	}
+
+	client.restartMarker = ""
+	client.blockConfig.RestartOffset = start
+	if code, msg, err := client.cmd(cmd.StatusFileActionPending, "RANG %d %d", start, end-1); err != nil {
+		if code == cmd.SYNTAX_ERROR || code == cmd.StatusNotImplemented {
+			return ErrRangeNotSupported
+		}
+		return replyError(code, msg, err)
+	}
+
+	if code, msg, err := client.cmd(cmd.ALREADY_OPEN, "RETR %s", remote); err != nil {
+		return replyError(code, msg, err)
+	}
+
+	dst := &rangeWriter{w: localFile, offset: start}
+	if err := client.retrieveData(dst); err != nil {
+		// A damaged range is retrieved again by retryRange.
+		client.closeDataConn()
+		client.readTransferResponse()
+		return err
+	}
+
+	if code, msg, err := client.readTransferResponse(); err != nil {
+		return replyError(code, msg, err)
+	}
+	if dst.offset != end {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// Writes in sequence from offset.
+type rangeWriter struct {
+	w      io.WriterAt
+	offset int64
+}
+
+func (w *rangeWriter) Write(p []byte) (int, error) {
+	n, err := w.w.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
+
+// Opens another session to the server, logged in as the same user and with
+// the same transfer and verify parameters as this one.
+func (client *clientImpl) newSession() (*clientImpl, error) {
+	session := defaultFtpClient()
+	if err := session.createCtrlConn(client.addr); err != nil {
+		return nil, err
+	}
+	session.connMode = client.connMode
+	session.rootDir = client.rootDir
+	session.limiter = client.limiter // the rate limit is shared
+	session.blockConfig.RestartInterval = client.blockConfig.RestartInterval
+	session.verify = client.verify
+	session.maxRetries, session.retryBackoff = client.maxRetries, client.retryBackoff
+
+	if err := session.copyParams(client); err != nil {
+		session.ctrlConn.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+func (session *clientImpl) copyParams(client *clientImpl) error {
+	if client.username != "" {
+		if err := session.Login(client.username, client.password); err != nil {
+			return err
+		}
+	}
+	if client.type_ != session.type_ {
+		if err := session.Type(client.type_); err != nil {
+			return err
+		}
+	}
+	if client.stru != session.stru {
+		if err := session.Structure(client.stru); err != nil {
+			return err
+		}
+	}
+	if client.hashAlgorithm != session.hashAlgorithm {
+		if err := session.SetHashAlgorithm(client.hashAlgorithm); err != nil {
+			return err
+		}
+	}
+	if client.mode != session.mode {
+		if err := session.Mode(client.mode); err != nil {
+			return err
+		}
+	}
+
+	switch client.mode {
+	case ModeDeflate:
+		if client.deflateLevel != session.deflateLevel {
+			return session.SetDeflateLevel(client.deflateLevel)
+		}
+	case ModeBlock:
+		if client.blockConfig.Checksum {
+			if err := session.SetBlockChecksum(true); err != nil {
+				return err
+			}
+			if err := session.SetBlockHash(client.blockConfig.Hash.String()); err != nil {
+				return err
+			}
+		}
+		if client.blockConfig.Repair {
+			if err := session.SetBlockRepair(true); err != nil {
+				return err
+			}
+		}
+		if client.blockConfig.BlockSize != 0 {
+			return session.SetBlockSize(client.blockConfig.BlockSize)
+		}
+	}
+	return nil
+}
+
+// Logs out and closes the connections of a session.
+func (client *clientImpl) close() {
+	client.closeDataConn()
+	client.Logout()
+	client.ctrlConn.Close()
+}
@@ -0,0 +1,167 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRetrieveParallel(t *testing.T) {
+	os.Mkdir("_test_", 0777)
+	defer os.RemoveAll("_test_")
+	defer func(size int64) { minRangeSize = size }(minRangeSize)
+	minRangeSize = 1000
+
+	file := make([]byte, 9993)
+	rand.Read(file)
+	var noRange, damaged, wrongDigest int32
+	lines := make(chan string, 64)
+
+	listener, _ := net.Listen("tcp", ":8984")
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				server := textproto.NewConn(conn)
+				defer server.Close()
+
+				var dataConn net.Conn
+				var start, end int64
+				server.Writer.PrintfLine("220 Service ready for new user.")
+				for {
+					line, err := server.ReadLine()
+					if err != nil {
+						return
+					}
+					lines <- line
+					command := strings.SplitN(line, " ", 2)
+					switch command[0] {
+					case "FEAT":
+						server.Writer.PrintfLine("211-Extensions supported:")
+						server.Writer.PrintfLine(" RANG STREAM")
+						server.Writer.PrintfLine(" XSHA256")
+						server.Writer.PrintfLine("211 End")
+					case "XSHA256":
+						digest := sha256.Sum256(file)
+						if atomic.LoadInt32(&wrongDigest) == 1 {
+							digest = sha256.Sum256(nil)
+						}
+						server.Writer.PrintfLine("250 %s", hex.EncodeToString(digest[:]))
+					case "USER":
+						server.Writer.PrintfLine("331 User name okay, need password.")
+					case "PASS":
+						server.Writer.PrintfLine("230 User logged in, proceed.")
+					case "PORT":
+						var h1, h2, h3, h4, p1, p2 byte
+						fmt.Sscanf(line, "PORT %d,%d,%d,%d,%d,%d", &h1, &h2, &h3, &h4, &p1, &p2)
+						dataConn, _ = net.Dial("tcp", fmt.Sprintf("%d.%d.%d.%d:%d", h1, h2, h3, h4, int(p1)*256+int(p2)))
+						server.Writer.PrintfLine("200 Command okay.")
+					case "SIZE":
+						server.Writer.PrintfLine("213 %d", len(file))
+					case "RANG":
+						if atomic.LoadInt32(&noRange) == 1 {
+							server.Writer.PrintfLine("502 Command not implemented.")
+							break
+						}
+						fmt.Sscanf(command[1], "%d %d", &start, &end)
+						end++
+						server.Writer.PrintfLine("350 Restarting at %d. Ending byte range at %d.", start, end-1)
+					case "RETR":
+						if end == 0 {
+							end = int64(len(file))
+						}
+						server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+						// A damaged stream ends in the middle of its range.
+						if atomic.CompareAndSwapInt32(&damaged, 1, 0) {
+							end = (start + end) / 2
+						}
+						dataConn.Write(file[start:end])
+						dataConn.Close()
+						start, end = 0, 0
+						server.Writer.PrintfLine("250 Requested file action okay, completed.")
+					case "QUIT":
+						server.Writer.PrintfLine("221 Service closing control connection.")
+						return
+					default:
+						server.Writer.PrintfLine("200 Command okay.")
+					}
+				}
+			}()
+		}
+	}()
+
+	client, err := NewFtpClient("localhost:8984")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Login("user", "password")
+	client.Type(TypeBinary)
+
+	if err := client.RetrieveParallel("_test_/parallel", "file"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile("_test_/parallel"); !bytes.Equal(data, file) {
+		t.Fatal("file not equal")
+	}
+	count := func() (users, rangs, hashes int) {
+		for len(lines) > 0 {
+			line := <-lines
+			if line == "USER user" {
+				users++
+			}
+			if strings.HasPrefix(line, "RANG") {
+				rangs++
+			}
+			if line == "XSHA256 file" {
+				hashes++
+			}
+		}
+		return
+	}
+	// The file put together is checked by the command the server lists.
+	if users, rangs, hashes := count(); users != DefaultParallelStreams || rangs != DefaultParallelStreams || hashes != 1 {
+		t.Fatalf("%d sessions, %d ranges, %d hashes", users, rangs, hashes)
+	}
+
+	// A damaged range is retrieved again, the others are not.
+	atomic.StoreInt32(&damaged, 1)
+	os.WriteFile("_test_/parallel", nil, 0666)
+	if err := client.RetrieveParallel("_test_/parallel", "file"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile("_test_/parallel"); !bytes.Equal(data, file) {
+		t.Fatal("file not equal")
+	}
+	if _, rangs, _ := count(); rangs != DefaultParallelStreams+1 {
+		t.Fatalf("%d ranges", rangs)
+	}
+
+	atomic.StoreInt32(&wrongDigest, 1)
+	if err := client.RetrieveParallel("_test_/parallel", "file"); err != ErrVerifyFailed {
+		t.Fatalf("got %v", err)
+	}
+	atomic.StoreInt32(&wrongDigest, 0)
+
+	// Without RANG the file is retrieved over one session.
+	atomic.StoreInt32(&noRange, 1)
+	client.SetParallelStreams(2)
+	os.WriteFile("_test_/parallel", nil, 0666)
+	if err := client.RetrieveParallel("_test_/parallel", "file"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile("_test_/parallel"); !bytes.Equal(data, file) {
+		t.Fatal("file not equal")
+	}
+}
@@ -1,7 +1,10 @@
 package client
 
 import (
+	"compress/zlib"
 	"errors"
+	"fmt"
+	"ftp/block"
 	"ftp/cmd"
 )
 
@@ -12,11 +15,19 @@ const (
 	ModeStream     byte = 'S'
 	ModeBlock      byte = 'B'
 	ModeCompressed byte = 'C'
+	ModeDeflate    byte = 'Z' // MODE Z, the data is compressed by zlib
 
 	TypeAscii  byte = 'A'
+	TypeEbcdic byte = 'E'
 	TypeBinary byte = 'I'
+	TypeLocal  byte = 'L' // TYPE L 8, the same as binary
 
-	StruFile byte = 'F'
+	FormNonPrint        byte = 'N'
+	FormTelnet          byte = 'T'
+	FormCarriageControl byte = 'C'
+
+	StruFile   byte = 'F'
+	StruRecord byte = 'R' // each line of a local file is a record
 )
 
 var (
@@ -24,7 +35,9 @@ var (
 	ErrInvalidPasvResponse  = errors.New("invalid pasv response")
 	ErrModeNotSupported     = errors.New("mode not support")
 	ErrTypeNotSupported     = errors.New("type not support")
+	ErrFormNotSupported     = errors.New("form not support")
 	ErrStruNotSupported     = errors.New("stru not support")
+	ErrDeflateLevel         = errors.New("invalid deflate level")
 )
 
 func (client *clientImpl) ConnMode(mode byte) error {
@@ -40,7 +53,11 @@ func (client clientImpl) GetConnMode() byte {
 }
 
 func (client *clientImpl) Mode(mode byte) error {
-	if mode != ModeStream && mode != ModeBlock && mode != ModeCompressed {
+	if mode != ModeStream && mode != ModeBlock && mode != ModeCompressed && mode != ModeDeflate {
+		return ErrModeNotSupported
+	}
+	// MODE Z is an extension, not sent to a server which does not list it.
+	if mode == ModeDeflate && !client.mayHave("MODE", "Z") {
 		return ErrModeNotSupported
 	}
 
@@ -48,7 +65,7 @@ func (client *clientImpl) Mode(mode byte) error {
 		if code == cmd.StatusParamNotImplemented {
 			return ErrModeNotSupported
 		}
-		return errors.New(msg)
+		return replyError(code, msg, err)
 	}
 
 	client.mode = mode
@@ -60,16 +77,85 @@ func (client clientImpl) GetMode() byte {
 	return client.mode
 }
 
+// Sets the zlib compression level of MODE Z, from -2 (Huffman only) to 9 (best
+// compression). The level applies to both sides of the data connection.
+func (client *clientImpl) SetDeflateLevel(level int) error {
+	if level < zlib.HuffmanOnly || level > zlib.BestCompression {
+		return ErrDeflateLevel
+	}
+
+	if code, msg, err := client.cmd(cmd.OK, "OPTS MODE Z LEVEL %d", level); err != nil {
+		return replyError(code, msg, err)
+	}
+
+	client.deflateLevel = level
+	return nil
+}
+
+// The checksummed framing is an extension of this implementation, so the
+// server has to agree on it.
+func (client *clientImpl) SetBlockChecksum(enabled bool) error {
+	framing := "STANDARD"
+	if enabled {
+		framing = "CHECKSUM"
+		if !client.mayHave("MODE", "B CHECKSUM") {
+			return ErrModeNotSupported
+		}
+	}
+
+	if code, msg, err := client.cmd(cmd.OK, "OPTS MODE B FRAMING %s", framing); err != nil {
+		return replyError(code, msg, err)
+	}
+
+	client.blockConfig.Checksum = enabled
+	return nil
+}
+
+// Sets the checksum algorithm of the checksummed framing by its name, FNV,
+// CRC32C, XXH64 or SHA-256. The server checksums the blocks it sends with it
+// as well.
+func (client *clientImpl) SetBlockHash(algorithm string) error {
+	h, err := block.ParseHash(algorithm)
+	if err != nil {
+		return err
+	}
+
+	if code, msg, err := client.cmd(cmd.OK, "OPTS MODE B HASH %s", h); err != nil {
+		return replyError(code, msg, err)
+	}
+
+	client.blockConfig.Hash = h
+	return nil
+}
+
 func (client *clientImpl) Type(type_ byte) error {
-	if type_ != TypeAscii && type_ != TypeBinary {
+	switch type_ {
+	case TypeAscii, TypeEbcdic, TypeBinary:
+		return client.typeCmd(type_, string(type_))
+	case TypeLocal:
+		return client.typeCmd(type_, "L 8")
+	default:
+		return ErrTypeNotSupported
+	}
+}
+
+// Sets an ASCII or EBCDIC type with the given form.
+func (client *clientImpl) TypeForm(type_, form byte) error {
+	if type_ != TypeAscii && type_ != TypeEbcdic {
 		return ErrTypeNotSupported
 	}
+	if form != FormNonPrint && form != FormTelnet && form != FormCarriageControl {
+		return ErrFormNotSupported
+	}
+	return client.typeCmd(type_, fmt.Sprintf("%c %c", type_, form))
+}
 
-	if code, msg, err := client.cmd(cmd.OK, "TYPE %c", type_); err != nil {
+func (client *clientImpl) typeCmd(type_ byte, param string) error {
+	if code, msg, err := client.cmd(cmd.OK, "TYPE %s", param); err != nil {
 		if code == cmd.StatusParamNotImplemented {
 			return ErrTypeNotSupported
 		}
-		return errors.New(msg)
+		return replyError(code, msg, err)
 	}
 
 	client.type_ = type_
@@ -82,7 +168,7 @@ func (cleint clientImpl) GetType() byte {
 }
 
 func (client *clientImpl) Structure(stru byte) error {
-	if stru != StruFile {
+	if stru != StruFile && stru != StruRecord {
 		return ErrStruNotSupported
 	}
 
@@ -90,7 +176,7 @@ func (client *clientImpl) Structure(stru byte) error {
 		if code == cmd.StatusParamNotImplemented {
 			return ErrStruNotSupported
 		}
-		return errors.New(msg)
+		return replyError(code, msg, err)
 	}
 
 	client.stru = stru
@@ -16,6 +16,7 @@ func TestMode(t *testing.T) {
 		server := textproto.NewConn(conn)
 		defer server.Close()
 		server.Writer.PrintfLine("220 Service ready for new user.")
+		answerFeat(server)
 
 		for {
 			if line, _ := server.ReadLine(); strings.HasPrefix(line, "MODE S") ||
@@ -52,6 +53,7 @@ func TestType(t *testing.T) {
 		server := textproto.NewConn(conn)
 		defer server.Close()
 		server.Writer.PrintfLine("220 Service ready for new user.")
+		answerFeat(server)
 
 		for {
 			if line, _ := server.ReadLine(); strings.HasPrefix(line, "TYPE A") ||
@@ -92,6 +94,7 @@ func TestStru(t *testing.T) {
 		server := textproto.NewConn(conn)
 		defer server.Close()
 		server.Writer.PrintfLine("220 Service ready for new user.")
+		answerFeat(server)
 
 		for {
 			if line, _ := server.ReadLine(); strings.HasPrefix(line, "STRU F") {
@@ -112,3 +115,58 @@ func TestStru(t *testing.T) {
 		t.Fatal("should not change stru")
 	}
 }
+
+func TestTypeForm(t *testing.T) {
+	lines := make(chan string, 8)
+	listener, _ := net.Listen("tcp", ":8975")
+	go func() {
+		conn, _ := listener.Accept()
+		listener.Close()
+
+		server := textproto.NewConn(conn)
+		defer server.Close()
+		server.Writer.PrintfLine("220 Service ready for new user.")
+		answerFeat(server)
+
+		for {
+			line, err := server.ReadLine()
+			if err != nil {
+				return
+			}
+			lines <- line
+			if strings.HasPrefix(line, "TYPE A C") {
+				server.Writer.PrintfLine("504 Command not implemented for that parameter.")
+			} else {
+				server.Writer.PrintfLine("200 Command okay.")
+			}
+		}
+	}()
+
+	client, err := NewFtpClient("localhost:8975")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Type(TypeLocal); err != nil || client.GetType() != TypeLocal {
+		t.Fatal(err)
+	}
+	if line := <-lines; line != "TYPE L 8" {
+		t.Fatalf("unexpected command %q", line)
+	}
+
+	if err := client.TypeForm(TypeEbcdic, FormTelnet); err != nil || client.GetType() != TypeEbcdic {
+		t.Fatal(err)
+	}
+	if line := <-lines; line != "TYPE E T" {
+		t.Fatalf("unexpected command %q", line)
+	}
+
+	if err := client.TypeForm(TypeAscii, FormCarriageControl); !errors.Is(err, ErrTypeNotSupported) ||
+		client.GetType() != TypeEbcdic {
+		t.Fatal("should not change type")
+	}
+
+	if err := client.TypeForm(TypeBinary, FormNonPrint); !errors.Is(err, ErrTypeNotSupported) {
+		t.Fatal("binary type has no form")
+	}
+}
@@ -0,0 +1,281 @@
+package client
+
+import (
+	"compress/zlib"
+	"errors"
+	"ftp/block"
+	"ftp/cmd"
+	"sync"
+	"time"
+)
+
+var ErrPoolClosed = errors.New("pool closed")
+
+// A Pool hands out logged-in clients, kept by the address and the
+// credentials they are opened with. A client is returned by Put to be reused,
+// or by Discard after it has failed.
+//
+// A client put back is reset to the defaults of a new one, and an idle client
+// is checked by NOOP before it is handed out again. Idle clients older than
+// the idle timeout are stale and closed.
+type Pool struct {
+	maxIdle     int // idle clients of a key, more are closed
+	maxOpen     int // clients of a key at a time, 0 is unlimited
+	idleTimeout time.Duration
+
+	mu     sync.Mutex
+	freed  *sync.Cond // a client is closed or put back
+	keys   map[poolKey]*poolEntry
+	owners map[*clientImpl]poolKey
+	closed bool
+}
+
+type poolKey struct {
+	addr, username, password string
+}
+
+type poolEntry struct {
+	idle []idleClient // the most recently put last
+	open int          // clients handed out and idle
+}
+
+type idleClient struct {
+	client *clientImpl
+	since  time.Time
+}
+
+// Creates a pool of at most maxOpen clients per key, of which maxIdle are
+// kept idle for at most idleTimeout seconds. maxOpen 0 is unlimited,
+// idleTimeout 0 keeps idle clients for ever.
+func NewPool(maxIdle, maxOpen, idleTimeout int) *Pool {
+	p := &Pool{
+		maxIdle:     maxIdle,
+		maxOpen:     maxOpen,
+		idleTimeout: time.Duration(idleTimeout) * time.Second,
+		keys:        make(map[poolKey]*poolEntry),
+		owners:      make(map[*clientImpl]poolKey),
+	}
+	p.freed = sync.NewCond(&p.mu)
+	return p
+}
+
+// Returns an idle client of the key which answers NOOP, or opens and logs in
+// a new one. Get waits for a client of the key to be freed if maxOpen are
+// open.
+func (p *Pool) Get(addr, username, password string) (FtpClient, error) {
+	key := poolKey{addr, username, password}
+
+	p.mu.Lock()
+	for {
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		p.closeStale()
+		entry := p.entry(key)
+
+		if n := len(entry.idle); n > 0 {
+			client := entry.idle[n-1].client
+			entry.idle = entry.idle[:n-1]
+			p.mu.Unlock()
+			if err := client.Noop(); err == nil {
+				return client, nil
+			}
+			p.Discard(client)
+			p.mu.Lock()
+			continue
+		}
+
+		if p.maxOpen == 0 || entry.open < p.maxOpen {
+			entry.open++
+			p.mu.Unlock()
+			client, err := p.open(key)
+			p.mu.Lock()
+			if err != nil {
+				entry.open--
+				p.freed.Broadcast()
+				p.mu.Unlock()
+				return nil, err
+			}
+			p.owners[client] = key
+			p.mu.Unlock()
+			return client, nil
+		}
+		p.freed.Wait()
+	}
+}
+
+func (p *Pool) open(key poolKey) (*clientImpl, error) {
+	client := defaultFtpClient()
+	if err := client.createCtrlConn(key.addr); err != nil {
+		return nil, err
+	}
+	if err := client.Login(key.username, key.password); err != nil {
+		client.ctrlConn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// Returns a client got from the pool. It is reset and kept idle, or closed if
+// it can not be reset or there are maxIdle idle clients of its key.
+func (p *Pool) Put(c FtpClient) {
+	client := c.(*clientImpl)
+	if err := client.reset(); err != nil {
+		p.Discard(client)
+		return
+	}
+
+	p.mu.Lock()
+	key, has := p.owners[client]
+	entry := p.keys[key]
+	if !has || p.closed || len(entry.idle) >= p.maxIdle {
+		p.mu.Unlock()
+		p.Discard(client)
+		return
+	}
+	entry.idle = append(entry.idle, idleClient{client, time.Now()})
+	p.freed.Broadcast()
+	p.mu.Unlock()
+}
+
+// Closes a client got from the pool, which frees its place.
+func (p *Pool) Discard(c FtpClient) {
+	client := c.(*clientImpl)
+
+	p.mu.Lock()
+	if key, has := p.owners[client]; has {
+		delete(p.owners, client)
+		p.keys[key].open--
+		p.freed.Broadcast()
+	}
+	p.mu.Unlock()
+
+	client.close()
+}
+
+// Closes the idle clients. The clients handed out are closed when they are
+// put back.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	var idle []*clientImpl
+	for _, entry := range p.keys {
+		for _, c := range entry.idle {
+			idle = append(idle, c.client)
+		}
+		entry.idle = nil
+	}
+	p.freed.Broadcast()
+	p.mu.Unlock()
+
+	for _, client := range idle {
+		p.Discard(client)
+	}
+}
+
+// The number of clients of the key handed out and idle.
+func (p *Pool) Open(addr, username, password string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.entry(poolKey{addr, username, password}).open
+}
+
+// Called with mu held.
+func (p *Pool) entry(key poolKey) *poolEntry {
+	entry, has := p.keys[key]
+	if !has {
+		entry = &poolEntry{}
+		p.keys[key] = entry
+	}
+	return entry
+}
+
+// Closes the idle clients older than the idle timeout, called with mu held.
+func (p *Pool) closeStale() {
+	if p.idleTimeout <= 0 {
+		return
+	}
+	for key, entry := range p.keys {
+		// The oldest are first.
+		n := 0
+		for n < len(entry.idle) && time.Since(entry.idle[n].since) > p.idleTimeout {
+			client := entry.idle[n].client
+			delete(p.owners, client)
+			entry.open--
+			go client.close()
+			n++
+		}
+		entry.idle = entry.idle[n:]
+		if n > 0 {
+			p.freed.Broadcast()
+		}
+		if entry.open == 0 {
+			delete(p.keys, key)
+		}
+	}
+}
+
+// Resets the session to the parameters of a new client.
+func (client *clientImpl) reset() error {
+	client.SetKeepalive(0)
+	client.closeDataConn()
+	fresh := defaultFtpClient()
+
+	if client.type_ != fresh.type_ {
+		if err := client.Type(fresh.type_); err != nil {
+			return err
+		}
+	}
+	if client.stru != fresh.stru {
+		if err := client.Structure(fresh.stru); err != nil {
+			return err
+		}
+	}
+	if client.mode != fresh.mode {
+		if err := client.Mode(fresh.mode); err != nil {
+			return err
+		}
+	}
+	if client.deflateLevel != zlib.DefaultCompression {
+		if err := client.SetDeflateLevel(zlib.DefaultCompression); err != nil {
+			return err
+		}
+	}
+	if client.blockConfig.Checksum {
+		if err := client.SetBlockChecksum(false); err != nil {
+			return err
+		}
+	}
+	if client.blockConfig.Hash != block.HashFNV {
+		if err := client.SetBlockHash(block.HashFNV.String()); err != nil {
+			return err
+		}
+	}
+	if client.blockConfig.Repair {
+		if err := client.SetBlockRepair(false); err != nil {
+			return err
+		}
+	}
+	if client.blockConfig.BlockSize != 0 {
+		if err := client.SetBlockSize(block.DefaultBlockSize); err != nil {
+			return err
+		}
+	}
+	if client.pipeline {
+		if code, msg, err := client.cmd(cmd.OK, "OPTS MODE B PIPELINE OFF"); err != nil {
+			return replyError(code, msg, err)
+		}
+	}
+	if client.hashAlgorithm != fresh.hashAlgorithm {
+		if err := client.SetHashAlgorithm(fresh.hashAlgorithm); err != nil {
+			return err
+		}
+	}
+
+	fresh.addr, fresh.ctrlConn, fresh.ctrl = client.addr, client.ctrlConn, client.ctrl
+	fresh.features = client.features
+	fresh.username, fresh.password = client.username, client.password
+	*client = *fresh
+	return nil
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	var mu sync.Mutex
+	var conns []net.Conn
+	lines := make(chan string, 64)
+
+	listener, _ := net.Listen("tcp", ":8989")
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			go func() {
+				server := textproto.NewConn(conn)
+				defer server.Close()
+
+				server.Writer.PrintfLine("220 Service ready for new user.")
+				for {
+					line, err := server.ReadLine()
+					if err != nil {
+						return
+					}
+					lines <- line
+					switch strings.SplitN(line, " ", 2)[0] {
+					case "USER":
+						server.Writer.PrintfLine("331 User name okay, need password.")
+					case "PASS":
+						server.Writer.PrintfLine("230 User logged in, proceed.")
+					case "QUIT":
+						server.Writer.PrintfLine("221 Service closing control connection.")
+						return
+					default:
+						server.Writer.PrintfLine("200 Command okay.")
+					}
+				}
+			}()
+		}
+	}()
+	sessions := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(conns)
+	}
+	drain := func() (got []string) {
+		for len(lines) > 0 {
+			got = append(got, <-lines)
+		}
+		return
+	}
+
+	pool := NewPool(1, 2, 1)
+	defer pool.Close()
+	if pool.idleTimeout != time.Second {
+		t.Fatalf("idle timeout %v", pool.idleTimeout)
+	}
+	pool.idleTimeout = 200 * time.Millisecond
+
+	client, err := pool.Get("localhost:8989", "user", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Type(TypeAscii)
+	client.Mode(ModeBlock)
+	drain()
+
+	// A client put back is reset, and checked by NOOP when it is reused.
+	pool.Put(client)
+	if got := strings.Join(drain(), ","); got != "TYPE I,MODE S" {
+		t.Fatalf("got %s", got)
+	}
+	reused, err := pool.Get("localhost:8989", "user", "password")
+	if err != nil || reused != client || sessions() != 1 {
+		t.Fatalf("got %v, %d sessions", err, sessions())
+	}
+	if got := strings.Join(drain(), ","); got != "NOOP" || reused.GetType() != TypeBinary {
+		t.Fatalf("got %s", got)
+	}
+
+	// At most 2 clients are open, the third waits for one to be freed.
+	second, _ := pool.Get("localhost:8989", "user", "password")
+	got := make(chan FtpClient)
+	go func() {
+		third, _ := pool.Get("localhost:8989", "user", "password")
+		got <- third
+	}()
+	select {
+	case <-got:
+		t.Fatal("more than 2 clients open")
+	case <-time.After(50 * time.Millisecond):
+	}
+	pool.Discard(second)
+	third := <-got
+	if third == nil || pool.Open("localhost:8989", "user", "password") != 2 {
+		t.Fatal("no client freed")
+	}
+
+	// Only one client is kept idle.
+	pool.Put(reused)
+	pool.Put(third)
+	if pool.Open("localhost:8989", "user", "password") != 1 {
+		t.Fatalf("%d open", pool.Open("localhost:8989", "user", "password"))
+	}
+
+	// A client whose connection is lost is replaced.
+	mu.Lock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+	mu.Unlock()
+	n := sessions()
+	if client, err = pool.Get("localhost:8989", "user", "password"); err != nil || sessions() != n+1 {
+		t.Fatalf("got %v, %d sessions", err, sessions())
+	}
+
+	// A stale client is closed.
+	pool.Put(client)
+	time.Sleep(300 * time.Millisecond)
+	drain()
+	if _, err = pool.Get("localhost:8989", "user", "password"); err != nil || sessions() != n+2 {
+		t.Fatalf("got %v, %d sessions", err, sessions())
+	}
+}
@@ -0,0 +1,285 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"ftp/cmd"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+)
+
+const (
+	JobStore    = "store"
+	JobRetrieve = "retrieve"
+
+	JobPending  = "pending"
+	JobRunning  = "running"
+	JobPaused   = "paused"
+	JobDone     = "done"
+	JobFailed   = "failed"
+	JobCanceled = "canceled"
+)
+
+var (
+	ErrQueueClient = errors.New("queue needs a client of NewFtpClient")
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobState    = errors.New("job can not change from its state")
+)
+
+// A transfer in a Queue.
+type Job struct {
+	ID     int64  `json:"id"`
+	Kind   string `json:"kind"`
+	Local  string `json:"local"`
+	Remote string `json:"remote"`
+	State  string `json:"state"`
+	// The number of times the job has been started.
+	Attempts int `json:"attempts"`
+	// The last restart marker acknowledged in a store, where it resumes.
+	Marker string `json:"marker,omitempty"`
+	Err    string `json:"error,omitempty"`
+}
+
+// A Queue runs transfer jobs one by one in the order they are enqueued, and
+// keeps them in a JSON file, so that the jobs left when the process is killed
+// are run again once the Queue is opened from the file.
+//
+// A retrieve which has been started before resumes by REST at the end of the
+// local file, and a store resumes at the last restart marker acknowledged,
+// which is saved as soon as it is, both only in a binary type. A store starts
+// again from the beginning if the server has not kept the part stored.
+type Queue struct {
+	client *clientImpl
+	file   string
+
+	mu       sync.Mutex // guards the jobs and stopping
+	jobs     queueFile
+	stopping bool
+}
+
+// The content of the queue file.
+type queueFile struct {
+	NextID int64  `json:"next_id"`
+	Jobs   []*Job `json:"jobs"`
+}
+
+// Opens the queue kept in file, which is created by the first change if it
+// does not exist. The jobs which were running are pending again.
+func OpenQueue(client FtpClient, file string) (*Queue, error) {
+	impl, ok := client.(*clientImpl)
+	if !ok {
+		return nil, ErrQueueClient
+	}
+	q := &Queue{client: impl, file: file, jobs: queueFile{NextID: 1}}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return q, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &q.jobs); err != nil {
+		return nil, err
+	}
+	for _, job := range q.jobs.Jobs {
+		if job.State == JobRunning {
+			job.State = JobPending
+		}
+	}
+	return q, nil
+}
+
+func (q *Queue) EnqueueStore(local, remote string) (int64, error) {
+	return q.enqueue(JobStore, local, remote)
+}
+
+func (q *Queue) EnqueueRetrieve(local, remote string) (int64, error) {
+	return q.enqueue(JobRetrieve, local, remote)
+}
+
+func (q *Queue) enqueue(kind, local, remote string) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job := &Job{ID: q.jobs.NextID, Kind: kind, Local: local, Remote: remote, State: JobPending}
+	q.jobs.NextID++
+	q.jobs.Jobs = append(q.jobs.Jobs, job)
+	return job.ID, q.save()
+}
+
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.jobs.Jobs)
+}
+
+// A copy of the job of index i, in the order of the queue.
+func (q *Queue) Job(i int) *Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job := *q.jobs.Jobs[i]
+	return &job
+}
+
+// A pending job is not run until it is resumed.
+func (q *Queue) Pause(id int64) error {
+	return q.change(id, JobPaused, JobPending)
+}
+
+func (q *Queue) Resume(id int64) error {
+	return q.change(id, JobPending, JobPaused)
+}
+
+// A failed or canceled job is pending again, and resumes where it failed.
+func (q *Queue) Retry(id int64) error {
+	return q.change(id, JobPending, JobFailed, JobCanceled)
+}
+
+// A job which is not running or done is never run.
+func (q *Queue) Cancel(id int64) error {
+	return q.change(id, JobCanceled, JobPending, JobPaused, JobFailed)
+}
+
+// Removes the jobs done or canceled from the queue.
+func (q *Queue) Clear() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	jobs := q.jobs.Jobs[:0]
+	for _, job := range q.jobs.Jobs {
+		if job.State != JobDone && job.State != JobCanceled {
+			jobs = append(jobs, job)
+		}
+	}
+	q.jobs.Jobs = jobs
+	return q.save()
+}
+
+// Changes the job to state if it is in one of the states from.
+func (q *Queue) change(id int64, state string, from ...string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, job := range q.jobs.Jobs {
+		if job.ID != id {
+			continue
+		}
+		for _, s := range from {
+			if job.State == s {
+				job.State = state
+				return q.save()
+			}
+		}
+		return ErrJobState
+	}
+	return ErrJobNotFound
+}
+
+// Runs the pending jobs in order until there is none left or Stop is called.
+// A failed job is kept with its error, and the queue goes on with the next.
+// The error returned is of the queue file only.
+func (q *Queue) Run() error {
+	q.mu.Lock()
+	q.stopping = false
+	q.mu.Unlock()
+
+	for {
+		job, err := q.next()
+		if job == nil || err != nil {
+			return err
+		}
+
+		err = q.run(job)
+
+		q.mu.Lock()
+		// A failed store keeps the last marker acknowledged.
+		if err != nil {
+			job.State, job.Err = JobFailed, err.Error()
+		} else {
+			job.State, job.Err, job.Marker = JobDone, "", ""
+		}
+		err = q.save()
+		q.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Stops Run after the job running.
+func (q *Queue) Stop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.stopping = true
+}
+
+// Takes the first pending job and marks it running, nil if Run stops.
+func (q *Queue) next() (*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.stopping {
+		return nil, nil
+	}
+	for _, job := range q.jobs.Jobs {
+		if job.State == JobPending {
+			job.State = JobRunning
+			job.Attempts++
+			return job, q.save()
+		}
+	}
+	return nil, nil
+}
+
+func (q *Queue) run(job *Job) error {
+	client := q.client
+	binary := client.type_ == TypeBinary || client.type_ == TypeLocal
+	if job.Kind == JobStore {
+		// Each marker is saved at once, for the store to resume from it even
+		// if the process is killed.
+		client.onRestartMarker = func(marker string) {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			job.Marker = marker
+			q.save()
+		}
+		defer func() { client.onRestartMarker = nil }()
+
+		if job.Marker != "" && binary {
+			err := client.RestartStore(job.Local, job.Remote, job.Marker)
+			var reply *ReplyError
+			if !errors.As(err, &reply) || reply.Code != cmd.StatusInvalidRestart {
+				return err
+			}
+			q.mu.Lock()
+			job.Marker = ""
+			q.mu.Unlock()
+		}
+		return client.Store(job.Local, job.Remote)
+	}
+
+	// The local file of a retrieve started before holds the data received.
+	if job.Attempts > 1 && binary {
+		if fs, err := os.Stat(path.Join(client.rootDir, job.Local)); err == nil && fs.Size() > 0 {
+			return client.RestartRetrieve(job.Local, job.Remote, strconv.FormatInt(fs.Size(), 10))
+		}
+	}
+	return client.Retrieve(job.Local, job.Remote)
+}
+
+// Writes the queue to a temporary file renamed over the file, so that a
+// process killed while saving leaves the last queue saved. Called with mu
+// held.
+func (q *Queue) save() error {
+	data, err := json.MarshalIndent(&q.jobs, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := q.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.file)
+}
@@ -0,0 +1,270 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"ftp/block"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueue(t *testing.T) {
+	os.Mkdir("_test_", 0777)
+	defer os.RemoveAll("_test_")
+
+	lines := make(chan string, 64)
+	listener, _ := net.Listen("tcp", ":8986")
+	go func() {
+		conn, _ := listener.Accept()
+		listener.Close()
+		server := textproto.NewConn(conn)
+		defer server.Close()
+
+		var dataConn net.Conn
+		var offset int
+		files := make(map[string][]byte)
+
+		server.Writer.PrintfLine("220 Service ready for new user.")
+		for {
+			line, err := server.ReadLine()
+			if err != nil {
+				return
+			}
+			lines <- line
+			command := strings.SplitN(line, " ", 2)
+			switch command[0] {
+			case "PORT":
+				var h1, h2, h3, h4, p1, p2 byte
+				fmt.Sscanf(line, "PORT %d,%d,%d,%d,%d,%d", &h1, &h2, &h3, &h4, &p1, &p2)
+				dataConn, _ = net.Dial("tcp", fmt.Sprintf("%d.%d.%d.%d:%d", h1, h2, h3, h4, int(p1)*256+int(p2)))
+				server.Writer.PrintfLine("200 Command okay.")
+			case "REST":
+				fmt.Sscanf(command[1], "%d", &offset)
+				server.Writer.PrintfLine("350 Requested file action pending further information.")
+			case "STOR":
+				server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+				data, _ := io.ReadAll(dataConn)
+				files[command[1]] = data
+				server.Writer.PrintfLine("250 Requested file action okay, completed.")
+			case "RETR":
+				data, has := files[command[1]]
+				if !has {
+					offset = 0
+					server.Writer.PrintfLine("550 File unavailable.")
+					break
+				}
+				server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+				dataConn.Write(data[offset:])
+				dataConn.Close()
+				offset = 0
+				server.Writer.PrintfLine("250 Requested file action okay, completed.")
+			default:
+				server.Writer.PrintfLine("200 Command okay.")
+			}
+		}
+	}()
+
+	client, err := NewFtpClient("localhost:8986")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Type(TypeBinary)
+
+	q, err := OpenQueue(client, "_test_/queue.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.EnqueueStore("test_files/small9993", "file")
+	q.EnqueueRetrieve("_test_/file", "file")
+	q.EnqueueRetrieve("_test_/none", "none")
+	paused, _ := q.EnqueueRetrieve("_test_/paused", "file")
+	if err := q.Pause(paused); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Resume(42); err != ErrJobNotFound {
+		t.Fatalf("got %v", err)
+	}
+	if err := q.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	local, _ := os.ReadFile("test_files/small9993")
+	if data, _ := os.ReadFile("_test_/file"); !bytes.Equal(data, local) {
+		t.Fatal("file not equal")
+	}
+	// The states are kept in the file.
+	q, err = OpenQueue(client, "_test_/queue.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := []string{JobDone, JobDone, JobFailed, JobPaused}
+	if q.Len() != len(states) {
+		t.Fatalf("%d jobs", q.Len())
+	}
+	for i, state := range states {
+		if job := q.Job(i); job.State != state {
+			t.Fatalf("job %d %s, want %s", job.ID, job.State, state)
+		}
+	}
+	if q.Job(2).Err == "" {
+		t.Fatal("a failed job should keep its error")
+	}
+	if err := q.Retry(paused); err != ErrJobState {
+		t.Fatalf("got %v", err)
+	}
+	if err := q.Cancel(paused); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Clear(); err != nil || q.Len() != 1 {
+		t.Fatalf("got %v, %d jobs", err, q.Len())
+	}
+
+	// A retrieve running when the process was killed resumes at the end of
+	// the local file.
+	os.WriteFile("_test_/resumed", local[:1000], 0666)
+	os.WriteFile("_test_/queue.json", []byte(`{"next_id": 2, "jobs": [
+		{"id": 1, "kind": "retrieve", "local": "_test_/resumed", "remote": "file", "state": "running", "attempts": 1}
+	]}`), 0666)
+	if q, err = OpenQueue(client, "_test_/queue.json"); err != nil {
+		t.Fatal(err)
+	}
+	for len(lines) > 0 {
+		<-lines
+	}
+	if err := q.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile("_test_/resumed"); !bytes.Equal(data, local) {
+		t.Fatal("resumed file not equal")
+	}
+	rest := false
+	for len(lines) > 0 {
+		if <-lines == "REST 1000" {
+			rest = true
+		}
+	}
+	if !rest || q.Job(0).State != JobDone {
+		t.Fatalf("rest %v, state %s", rest, q.Job(0).State)
+	}
+}
+
+func TestQueueKilledStore(t *testing.T) {
+	os.Mkdir("_test_", 0777)
+	defer os.RemoveAll("_test_")
+
+	lines := make(chan string, 64)
+	kill := make(chan struct{})
+	listener, _ := net.Listen("tcp", ":8992")
+	defer listener.Close()
+	go func() {
+		for first := true; ; first = false {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server := textproto.NewConn(conn)
+
+			var dataConn net.Conn
+			server.Writer.PrintfLine("220 Service ready for new user.")
+			for {
+				line, err := server.ReadLine()
+				if err != nil {
+					break
+				}
+				lines <- line
+				command := strings.SplitN(line, " ", 2)
+				if command[0] == "PORT" {
+					var h1, h2, h3, h4, p1, p2 byte
+					fmt.Sscanf(line, "PORT %d,%d,%d,%d,%d,%d", &h1, &h2, &h3, &h4, &p1, &p2)
+					dataConn, _ = net.Dial("tcp", fmt.Sprintf("%d.%d.%d.%d:%d", h1, h2, h3, h4, int(p1)*256+int(p2)))
+					server.Writer.PrintfLine("200 Command okay.")
+				} else if command[0] == "REST" {
+					server.Writer.PrintfLine("350 Requested file action pending further information.")
+				} else if command[0] == "STOR" && first {
+					// The process is killed after the first marker.
+					server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+					config := block.Config{OnRestart: func(marker string) {
+						server.Writer.PrintfLine("110 MARK %s = %s", marker, marker)
+						<-kill
+						dataConn.Close()
+						conn.Close()
+					}}
+					config.Receive(io.Discard, dataConn)
+				} else if command[0] == "STOR" {
+					server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+					block.Config{}.Receive(io.Discard, dataConn)
+					server.Writer.PrintfLine("250 Requested file action okay, completed.")
+				} else {
+					server.Writer.PrintfLine("200 Command okay.")
+				}
+			}
+			server.Close()
+		}
+	}()
+
+	open := func() *Queue {
+		client, err := NewFtpClient("localhost:8992")
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.Mode(ModeBlock)
+		client.SetRestartInterval(4096)
+		q, err := OpenQueue(client, "_test_/queue.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return q
+	}
+
+	if _, err := OpenQueue(nil, "_test_/queue.json"); err != ErrQueueClient {
+		t.Fatalf("got %v", err)
+	}
+	q := open()
+	q.EnqueueStore("test_files/small9993", "file")
+	ran := make(chan error)
+	go func() { ran <- q.Run() }()
+
+	// The marker is saved while the store is still running.
+	var job *Job
+	for i := 0; i < 100; i++ {
+		data, _ := os.ReadFile("_test_/queue.json")
+		var saved queueFile
+		if json.Unmarshal(data, &saved) == nil && len(saved.Jobs) == 1 && saved.Jobs[0].Marker != "" {
+			job = saved.Jobs[0]
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(kill)
+	<-ran
+	if job == nil || job.State != JobRunning || job.Marker != "4096" {
+		t.Fatalf("got %+v", job)
+	}
+
+	// Opened again as by another process, the store resumes at the marker.
+	os.WriteFile("_test_/queue.json", []byte(`{"next_id": 2, "jobs": [
+		{"id": 1, "kind": "store", "local": "test_files/small9993", "remote": "file", "state": "running", "attempts": 1, "marker": "4096"}
+	]}`), 0666)
+	q = open()
+	for len(lines) > 0 {
+		<-lines
+	}
+	if err := q.Run(); err != nil {
+		t.Fatal(err)
+	}
+	rest := false
+	for len(lines) > 0 {
+		if <-lines == "REST 4096" {
+			rest = true
+		}
+	}
+	if !rest || q.Job(0).State != JobDone {
+		t.Fatalf("rest %v, state %s", rest, q.Job(0).State)
+	}
+}
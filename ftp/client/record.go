@@ -0,0 +1,106 @@
+package client
+
+import (
+	"errors"
+	"ftp/cmd"
+	"ftp/record"
+)
+
+var (
+	ErrNotRecordStructure = errors.New("structure is not record")
+)
+
+// A sequence of records to store. ReadRecord returns io.EOF after the last
+// record.
+type RecordReader interface {
+	ReadRecord() ([]byte, error)
+}
+
+// Receives the retrieved records one by one.
+type RecordWriter interface {
+	WriteRecord(record []byte) error
+}
+
+// Stores the records as the remote file, the structure must be StruRecord.
+func (client *clientImpl) StoreRecords(remote string, records RecordReader) error {
+	if client.stru != StruRecord {
+		return ErrNotRecordStructure
+	}
+
+	if err := client.createDataConn(); err != nil {
+		return err
+	}
+
+	if code, msg, err := client.cmd(cmd.ALREADY_OPEN, "STOR %s", remote); err != nil {
+		return replyError(code, msg, err)
+	}
+
+	if err := client.storeRecords(records); err != nil {
+		// The server waits for the end of the records until the data
+		// connection is closed, as in storeFile.
+		client.closeDataConn()
+		client.readTransferResponse()
+		return err
+	}
+	if code, msg, err := client.readTransferResponse(); err != nil {
+		return replyError(code, msg, err)
+	}
+
+	return nil
+}
+
+// Retrieves the records of the remote file, the structure must be StruRecord.
+func (client *clientImpl) RetrieveRecords(remote string, records RecordWriter) error {
+	if client.stru != StruRecord {
+		return ErrNotRecordStructure
+	}
+
+	if err := client.createDataConn(); err != nil {
+		return err
+	}
+
+	if code, msg, err := client.cmd(cmd.ALREADY_OPEN, "RETR %s", remote); err != nil {
+		return replyError(code, msg, err)
+	}
+
+	if err := client.retrieveRecords(records); err != nil {
+		// The transfer is replied all the same, as in Retrieve.
+		client.closeDataConn()
+		client.readTransferResponse()
+		return err
+	}
+
+	if code, msg, err := client.readTransferResponse(); err != nil {
+		return replyError(code, msg, err)
+	}
+
+	return nil
+}
+
+// The end of a record is an escape sequence in stream mode and a descriptor
+// in block mode, the other modes do not support records.
+func (client *clientImpl) storeRecords(records record.Reader) error {
+	switch client.mode {
+	case ModeStream:
+		if err := record.Send(client.dataConn, records); err != nil {
+			return err
+		}
+		return client.closeDataConn()
+	case ModeBlock:
+		return client.blockConfig.SendRecords(client.dataConn, records)
+	default:
+		return ErrModeNotSupported
+	}
+}
+
+func (client *clientImpl) retrieveRecords(records record.Writer) error {
+	switch client.mode {
+	case ModeStream:
+		defer client.closeDataConn()
+		return record.Receive(records, client.dataConn)
+	case ModeBlock:
+		return client.blockConfig.ReceiveRecords(records, client.dataConn)
+	default:
+		return ErrModeNotSupported
+	}
+}
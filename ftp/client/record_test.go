@@ -0,0 +1,122 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"ftp/record"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+type records [][]byte
+
+func (r *records) ReadRecord() ([]byte, error) {
+	if len(*r) == 0 {
+		return nil, io.EOF
+	}
+	rec := (*r)[0]
+	*r = (*r)[1:]
+	return rec, nil
+}
+
+func (r *records) WriteRecord(record []byte) error {
+	*r = append(*r, record)
+	return nil
+}
+
+var errRecords = errors.New("records failed")
+
+// Fails once its records are read.
+type failingRecords struct {
+	records
+}
+
+func (r *failingRecords) ReadRecord() ([]byte, error) {
+	if len(r.records) == 0 {
+		return nil, errRecords
+	}
+	return r.records.ReadRecord()
+}
+
+func TestRecordStructure(t *testing.T) {
+	stored := make(chan records)
+	listener, _ := net.Listen("tcp", ":8978")
+	go func() {
+		conn, _ := listener.Accept()
+		listener.Close()
+		server := textproto.NewConn(conn)
+		defer server.Close()
+
+		var dataConn net.Conn
+		var file records
+
+		server.Writer.PrintfLine("220 Service ready for new user.")
+		answerFeat(server)
+		for {
+			line, err := server.ReadLine()
+			if err != nil {
+				return
+			}
+			if strings.HasPrefix(line, "PORT") {
+				var h1, h2, h3, h4, p1, p2 byte
+				fmt.Sscanf(line, "PORT %d,%d,%d,%d,%d,%d", &h1, &h2, &h3, &h4, &p1, &p2)
+				dataConn, _ = net.Dial("tcp", fmt.Sprintf("%d.%d.%d.%d:%d", h1, h2, h3, h4, int(p1)*256+int(p2)))
+				server.Writer.PrintfLine("200 Command okay.")
+			} else if strings.HasPrefix(line, "STRU") {
+				server.Writer.PrintfLine("200 Command okay.")
+			} else if strings.HasPrefix(line, "RETR ") {
+				server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+				sent := append(records{}, file...)
+				record.Send(dataConn, &sent)
+				dataConn.Close()
+				server.Writer.PrintfLine("250 Requested file action okay, completed.")
+			} else if strings.HasPrefix(line, "STOR ") {
+				server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+				file = nil
+				record.Receive(&file, dataConn)
+				dataConn.Close()
+				server.Writer.PrintfLine("250 Requested file action okay, completed.")
+				stored <- file
+			}
+		}
+	}()
+
+	client, err := NewFtpClient("localhost:8978")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.StoreRecords("records", &records{}); err != ErrNotRecordStructure {
+		t.Fatal("records need record structure")
+	}
+	if err := client.Structure(StruRecord); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := records{[]byte("rec 1"), {}, []byte("\xffrec 3")}
+	storeErr := make(chan error)
+	go func() { storeErr <- client.StoreRecords("records", &records{sent[0], sent[1], sent[2]}) }()
+	if data := <-stored; fmt.Sprintf("%q", data) != fmt.Sprintf("%q", sent) {
+		t.Fatalf("stored %q", data)
+	}
+	if err := <-storeErr; err != nil {
+		t.Fatal(err)
+	}
+
+	var received records
+	if err := client.RetrieveRecords("records", &received); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%q", received) != fmt.Sprintf("%q", sent) {
+		t.Fatalf("retrieved %q", received)
+	}
+
+	// A failed store closes the data connection, and the reply still comes.
+	go func() { storeErr <- client.StoreRecords("records", &failingRecords{records{sent[0]}}) }()
+	<-stored
+	if err := <-storeErr; err != errRecords {
+		t.Fatalf("got %v", err)
+	}
+}
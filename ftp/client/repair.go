@@ -0,0 +1,105 @@
+package client
+
+import (
+	"errors"
+	"ftp/block"
+	"ftp/cmd"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Times a block is resent before the transfer fails.
+const maxRepairAttempts = 3
+
+var ErrRepairFailed = errors.New("damaged blocks not repaired")
+
+// In the checksummed block framing, damaged blocks are resent by XBLK
+// instead of failing the transfer. Only binary types can be repaired.
+func (client *clientImpl) SetBlockRepair(enabled bool) error {
+	repair := "OFF"
+	if enabled {
+		repair = "ON"
+	}
+
+	if code, msg, err := client.cmd(cmd.OK, "OPTS MODE B REPAIR %s", repair); err != nil {
+		return replyError(code, msg, err)
+	}
+
+	client.blockConfig.Repair = enabled
+	return nil
+}
+
+// Resends the damaged blocks of a STOR, as listed in the 350 reply msg, until
+// the server completes the file.
+func (client *clientImpl) repairStore(localFile io.ReaderAt, msg string) error {
+	attempts := make(map[int64]int)
+	for {
+		indexes := damagedIndexes(msg)
+		if len(indexes) == 0 {
+			return ErrRepairFailed
+		}
+		index := indexes[0]
+		if attempts[index]++; attempts[index] > maxRepairAttempts {
+			return ErrRepairFailed
+		}
+
+		if code, msg, err := client.cmd(cmd.ALREADY_OPEN, "XBLK %d", index); err != nil {
+			return replyError(code, msg, err)
+		}
+		if err := client.blockConfig.Resend(client.dataConn, localFile, index); err != nil {
+			client.closeDataConn()
+			client.readTransferResponse()
+			return err
+		}
+
+		code, reply, err := client.readTransferResponse()
+		if code != cmd.StatusFileActionPending {
+			if err != nil {
+				return replyError(code, reply, err)
+			}
+			return nil
+		}
+		msg = reply
+	}
+}
+
+// The indexes in the lines of a 350 reply following the first one.
+func damagedIndexes(msg string) []int64 {
+	var indexes []int64
+	for _, line := range strings.Split(msg, "\n")[1:] {
+		if index, err := strconv.ParseInt(strings.TrimSpace(line), 10, 64); err == nil {
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes
+}
+
+// Asks the server to resend the damaged blocks of the RETR just completed,
+// and writes them in place.
+func (client *clientImpl) repairRetrieve(localFile io.WriterAt, damaged *block.DamagedError) error {
+	for _, index := range damaged.Indexes {
+		for attempt := 1; ; attempt++ {
+			if code, msg, err := client.cmd(cmd.ALREADY_OPEN, "XBLK %d", index); err != nil {
+				return replyError(code, msg, err)
+			}
+			err := client.blockConfig.ReceiveResent(localFile, index, client.dataConn)
+			if err != nil {
+				client.closeDataConn()
+			}
+			if code, msg, replyErr := client.readTransferResponse(); replyErr != nil {
+				return replyError(code, msg, replyErr)
+			}
+
+			if err == nil {
+				break
+			} else if !errors.Is(err, block.ErrBrokenBlock) || attempt == maxRepairAttempts {
+				return err
+			}
+			if err := client.createDataConn(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
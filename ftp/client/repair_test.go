@@ -0,0 +1,139 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"ftp/block"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Flips a bit of the byte at offset of the data read.
+type damagingReader struct {
+	r      io.Reader
+	offset int
+}
+
+func (d *damagingReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if d.offset >= 0 && d.offset < n {
+		p[d.offset] ^= 1
+	}
+	d.offset -= n
+	return n, err
+}
+
+type fileAt []byte
+
+func (f fileAt) WriteAt(p []byte, off int64) (int, error) {
+	return copy(f[off:], p), nil
+}
+
+func TestRepair(t *testing.T) {
+	os.Mkdir("_test_", 0777)
+	defer os.RemoveAll("_test_")
+
+	local, _ := os.ReadFile("test_files/small9993")
+	config := block.Config{Checksum: true, Repair: true}
+	stored := make(chan []byte, 1)
+	listener, _ := net.Listen("tcp", ":8981")
+	go func() {
+		conn, _ := listener.Accept()
+		listener.Close()
+		server := textproto.NewConn(conn)
+		defer server.Close()
+
+		var dataConn net.Conn
+		var file fileAt
+		var name string
+		retrieved := false
+
+		server.Writer.PrintfLine("220 Service ready for new user.")
+		answerFeat(server)
+		for {
+			line, err := server.ReadLine()
+			if err != nil {
+				return
+			}
+			if strings.HasPrefix(line, "PORT") {
+				var h1, h2, h3, h4, p1, p2 byte
+				fmt.Sscanf(line, "PORT %d,%d,%d,%d,%d,%d", &h1, &h2, &h3, &h4, &p1, &p2)
+				dataConn, _ = net.Dial("tcp", fmt.Sprintf("%d.%d.%d.%d:%d", h1, h2, h3, h4, int(p1)*256+int(p2)))
+				server.Writer.PrintfLine("200 Command okay.")
+			} else if strings.HasPrefix(line, "STOR ") {
+				name = strings.TrimPrefix(line, "STOR ")
+				server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+				// The block 2 is damaged on the way.
+				var data bytes.Buffer
+				err := config.Receive(&data, &damagingReader{dataConn, 9 + 2*(1<<10+17) + 5})
+				file = fileAt(data.Bytes())
+				if err == nil {
+					server.Writer.PrintfLine("451 Requested action aborted: local error in processing.")
+					continue
+				}
+				server.Writer.PrintfLine("350-Damaged blocks, resend them by XBLK:")
+				server.Writer.PrintfLine(" 2")
+				server.Writer.PrintfLine("350 Requested file action pending further information.")
+			} else if strings.HasPrefix(line, "RETR ") {
+				server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+				var data bytes.Buffer
+				config.Send(&data, bytes.NewReader(file))
+				data.Bytes()[9+3*(1<<10+17)] ^= 1
+				dataConn.Write(data.Bytes())
+				retrieved = true
+				server.Writer.PrintfLine("250 Requested file action okay, completed.")
+			} else if line == "XBLK 2" && name == "full" {
+				server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+				config.ReceiveResent(file, 2, dataConn)
+				server.Writer.PrintfLine("552 Requested file action aborted, exceeded storage allocation.")
+			} else if line == "XBLK 2" && !retrieved {
+				server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+				config.ReceiveResent(file, 2, dataConn)
+				server.Writer.PrintfLine("250 Requested file action okay, completed.")
+				stored <- file
+			} else if line == "XBLK 3" && retrieved {
+				server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+				config.Resend(dataConn, bytes.NewReader(file), 3)
+				server.Writer.PrintfLine("250 Requested file action okay, completed.")
+			} else {
+				server.Writer.PrintfLine("200 Command okay.")
+			}
+		}
+	}()
+
+	client, err := NewFtpClient("localhost:8981")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Mode(ModeBlock)
+	client.Type(TypeBinary)
+	client.SetBlockChecksum(true)
+	if err := client.SetBlockRepair(true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Store("test_files/small9993", "small9993"); err != nil {
+		t.Fatal(err)
+	}
+	if data := <-stored; !bytes.Equal(data, local) {
+		t.Fatal("stored file not repaired")
+	}
+
+	if err := client.Retrieve("_test_/small9993", "small9993"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile("_test_/small9993"); !bytes.Equal(data, local) {
+		t.Fatal("retrieved file not repaired")
+	}
+
+	// The reply refusing a repair keeps its code.
+	var reply *ReplyError
+	if err := client.Store("test_files/small9993", "full"); !errors.As(err, &reply) || reply.Code != 552 {
+		t.Fatalf("got %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"ftp/cmd"
+	"strconv"
+)
+
+const DefaultRestartInterval = 1 << 20
+
+var (
+	ErrRestartNotSupported  = errors.New("restart not support")
+	ErrInvalidRestartMarker = errors.New("invalid restart marker")
+)
+
+func (client *clientImpl) SetRestartInterval(bytes int64) {
+	client.blockConfig.RestartInterval = bytes
+}
+
+// The last restart marker acknowledged in the latest transfer, "" if there is
+// none. After a failure the transfer can be resumed from it by RestartStore
+// or RestartRetrieve.
+func (client clientImpl) GetRestartMarker() string {
+	return client.restartMarker
+}
+
+// Resumes a failed Store from the restart marker.
+func (client *clientImpl) RestartStore(local, remote, marker string) error {
+	offset, err := strconv.ParseInt(marker, 10, 64)
+	if err != nil || offset < 0 {
+		return ErrInvalidRestartMarker
+	}
+	return client.storeFile(local, remote, offset)
+}
+
+// Resumes a failed Retrieve from the restart marker, the local file is kept
+// up to the marker.
+func (client *clientImpl) RestartRetrieve(local, remote, marker string) error {
+	offset, err := strconv.ParseInt(marker, 10, 64)
+	if err != nil || offset < 0 {
+		return ErrInvalidRestartMarker
+	}
+	return client.retrieve(local, remote, offset)
+}
+
+// Sends REST before a transfer at a non-zero offset. A marker is the offset
+// in the transferred data, which is the offset in the local file only for
+// binary types.
+func (client *clientImpl) restart(offset int64) error {
+	client.blockConfig.RestartOffset = offset
+	if offset == 0 {
+		return nil
+	}
+
+	if client.type_ != TypeBinary && client.type_ != TypeLocal {
+		return ErrRestartNotSupported
+	}
+	if code, msg, err := client.cmd(cmd.StatusFileActionPending, "REST %d", offset); err != nil {
+		return replyError(code, msg, err)
+	}
+	return nil
+}
+
+// Reads the reply of a finished transfer. The restart markers acknowledged by
+// the server in 110 replies before it are kept for GetRestartMarker.
+func (client *clientImpl) readTransferResponse() (int, string, error) {
+	code, msg, err := client.readMarkers()
+	client.endTransfer()
+	return code, msg, err
+}
+
+// Reads the replies of a store while the file is sent, so that each restart
+// marker is known, and passed to onRestartMarker, as soon as the server
+// acknowledges it. The returned function waits for the reply of the transfer.
+func (client *clientImpl) readStoreResponse() func() (int, string, error) {
+	var code int
+	var msg string
+	var err error
+	done := make(chan struct{})
+	go func() {
+		code, msg, err = client.readMarkers()
+		close(done)
+	}()
+
+	return func() (int, string, error) {
+		<-done
+		client.endTransfer()
+		return code, msg, err
+	}
+}
+
+// Reads the 110 replies up to the reply which ends the transfer.
+func (client *clientImpl) readMarkers() (int, string, error) {
+	for {
+		code, msg, err := client.ctrlConn.ReadResponse(cmd.StatusFileActionCompleted)
+		if code != cmd.RESTART {
+			return code, msg, err
+		}
+
+		var marker string
+		if _, err := fmt.Sscanf(msg, "MARK %s =", &marker); err == nil {
+			client.restartMarker = marker
+			if client.onRestartMarker != nil {
+				client.onRestartMarker(marker)
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"ftp/block"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Writes up to n bytes, and fails after.
+type brokenWriter struct {
+	bytes.Buffer
+	n int
+}
+
+func (w *brokenWriter) Write(p []byte) (int, error) {
+	if w.Len()+len(p) > w.n {
+		return 0, errors.New("broken")
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestRestart(t *testing.T) {
+	os.Mkdir("_test_", 0777)
+	defer os.RemoveAll("_test_")
+
+	local, _ := os.ReadFile("test_files/small9993")
+	stored := make(chan []byte)
+	listener, _ := net.Listen("tcp", ":8980")
+	go func() {
+		conn, _ := listener.Accept()
+		listener.Close()
+		server := textproto.NewConn(conn)
+		defer server.Close()
+
+		var dataConn net.Conn
+		var offset int64
+		var file []byte
+		first := true
+
+		server.Writer.PrintfLine("220 Service ready for new user.")
+		answerFeat(server)
+		for {
+			line, err := server.ReadLine()
+			if err != nil {
+				return
+			}
+			if strings.HasPrefix(line, "PORT") {
+				var h1, h2, h3, h4, p1, p2 byte
+				fmt.Sscanf(line, "PORT %d,%d,%d,%d,%d,%d", &h1, &h2, &h3, &h4, &p1, &p2)
+				dataConn, _ = net.Dial("tcp", fmt.Sprintf("%d.%d.%d.%d:%d", h1, h2, h3, h4, int(p1)*256+int(p2)))
+				server.Writer.PrintfLine("200 Command okay.")
+			} else if strings.HasPrefix(line, "REST ") {
+				fmt.Sscanf(line, "REST %d", &offset)
+				server.Writer.PrintfLine("350 Requested file action pending further information.")
+			} else if strings.HasPrefix(line, "STOR ") {
+				server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+				config := block.Config{OnRestart: func(marker string) {
+					server.Writer.PrintfLine("110 MARK %s = %s", marker, marker)
+				}}
+				// The first upload breaks after 5000 bytes.
+				dst := &brokenWriter{n: len(local)}
+				if first {
+					dst.n = 5000
+				}
+				err := config.Receive(dst, dataConn)
+				file = append(file[:offset], dst.Bytes()...)
+				offset = 0
+				if err != nil {
+					dataConn.Close()
+					server.Writer.PrintfLine("451 Requested action aborted: local error in processing.")
+				} else {
+					server.Writer.PrintfLine("250 Requested file action okay, completed.")
+					stored <- file
+				}
+				first = false
+			} else if strings.HasPrefix(line, "RETR ") {
+				server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+				var data bytes.Buffer
+				block.Config{RestartInterval: 4096, RestartOffset: offset}.Send(&data, bytes.NewReader(file[offset:]))
+				// The first download breaks in the last block.
+				if offset == 0 {
+					data.Truncate(data.Len() - 1)
+				}
+				dataConn.Write(data.Bytes())
+				dataConn.Close()
+				offset = 0
+				server.Writer.PrintfLine("250 Requested file action okay, completed.")
+			} else {
+				server.Writer.PrintfLine("200 Command okay.")
+			}
+		}
+	}()
+
+	client, err := NewFtpClient("localhost:8980")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Mode(ModeBlock)
+	client.Type(TypeAscii)
+	if err := client.RestartStore("test_files/small9993", "small9993", "4096"); err != ErrRestartNotSupported {
+		t.Fatal("restart should not be supported in ascii type")
+	}
+	client.Type(TypeBinary)
+	client.SetRestartInterval(4096)
+
+	if err := client.Store("test_files/small9993", "small9993"); err == nil {
+		t.Fatal("the first store should fail")
+	}
+	if marker := client.GetRestartMarker(); marker != "4096" {
+		t.Fatalf("got marker %q", marker)
+	}
+	storeErr := make(chan error)
+	go func() {
+		storeErr <- client.RestartStore("test_files/small9993", "small9993", client.GetRestartMarker())
+	}()
+	select {
+	case data := <-stored:
+		if !bytes.Equal(data, local) {
+			t.Fatal("stored file not equal")
+		}
+		if err := <-storeErr; err != nil {
+			t.Fatal(err)
+		}
+	case err := <-storeErr:
+		t.Fatal(err)
+	}
+
+	if err := client.Retrieve("_test_/small9993", "small9993"); err == nil {
+		t.Fatal("the first retrieve should fail")
+	}
+	// The last marker before the last block.
+	if marker := client.GetRestartMarker(); marker != fmt.Sprint((len(local)-1)/4096*4096) {
+		t.Fatalf("got marker %q", marker)
+	}
+	if err := client.RestartRetrieve("_test_/small9993", "small9993", client.GetRestartMarker()); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile("_test_/small9993"); !bytes.Equal(data, local) {
+		t.Fatal("retrieved file not equal")
+	}
+}
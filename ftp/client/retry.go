@@ -0,0 +1,137 @@
+package client
+
+import (
+	"errors"
+	"ftp/cmd"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultRetryBackoff = time.Second
+	MaxRetryBackoff     = 30 * time.Second
+)
+
+// Sets how the operations which can be run again, Store, Retrieve, Size,
+// Checksum and Verify, are retried after a transient failure: up to
+// maxRetries times, waiting backoff milliseconds before the first retry and
+// twice as long before each next one, up to MaxRetryBackoff. A lost connection
+// is reconnected before the retry. 0 retries, the default, disables retrying.
+func (client *clientImpl) SetRetryPolicy(maxRetries, milliseconds int) {
+	backoff := time.Duration(milliseconds) * time.Millisecond
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+	client.maxRetries = maxRetries
+	client.retryBackoff = backoff
+}
+
+// Connects to the server again, logs in as the same user, and sets the
+// type, structure, mode and their options of the session again.
+func (client *clientImpl) Reconnect() error {
+	client.closeDataConn()
+	if client.ctrlConn != nil {
+		client.ctrlConn.Close()
+	}
+
+	session, err := client.newSession()
+	if err != nil {
+		return err
+	}
+	client.ctrl.Lock()
+	client.ctrlConn = session.ctrlConn
+	client.features = session.features
+	client.ctrl.transferring, client.ctrl.noops = false, 0
+	client.ctrl.Unlock()
+	client.pipeline = false
+	return nil
+}
+
+// Runs op, and runs it again by the retry policy as long as it fails with a
+// transient error, a 4xx reply or a lost connection.
+func (client *clientImpl) retry(op func() error) error {
+	err := op()
+	backoff := client.retryBackoff
+	for i := 0; i < client.maxRetries && err != nil; i++ {
+		lost := connectionLost(err)
+		var reply *ReplyError
+		if !lost && !(errors.As(err, &reply) && reply.Transient()) {
+			return err
+		}
+
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > MaxRetryBackoff {
+			backoff = MaxRetryBackoff
+		}
+		if lost {
+			if err = client.Reconnect(); err != nil {
+				continue
+			}
+		}
+		err = op()
+	}
+	return err
+}
+
+// Whether err is of a broken connection, or a reply by which the server
+// closes the control connection.
+func connectionLost(err error) bool {
+	var netErr net.Error
+	var protocolErr textproto.ProtocolError
+	var reply *ReplyError
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &netErr) || errors.As(err, &protocolErr) ||
+		(errors.As(err, &reply) && reply.Code == cmd.NOT_AVAILABLE)
+}
+
+// Retrieves the remote file, and a retry resumes at the end of the local file
+// in a binary type.
+func (client *clientImpl) Retrieve(local, remote string) error {
+	var offset int64
+	return client.retry(func() error {
+		client.attempt(offset)
+		err := client.retrieve(local, remote, offset)
+		if fs, statErr := os.Stat(path.Join(client.rootDir, local)); statErr == nil && client.binary() {
+			offset = fs.Size()
+		}
+		return err
+	})
+}
+
+// Stores the local file, and a retry resumes at the last restart marker
+// acknowledged in a binary type, or from the beginning if the server has not
+// kept the part stored.
+func (client *clientImpl) StoreFile(local, remote string) error {
+	var offset int64
+	return client.retry(func() error {
+		client.attempt(offset)
+		err := client.storeFile(local, remote, offset)
+		var reply *ReplyError
+		if offset > 0 && errors.As(err, &reply) && reply.Code == cmd.StatusInvalidRestart {
+			client.attempt(0)
+			err = client.storeFile(local, remote, 0)
+		}
+
+		offset = 0
+		if marker, parseErr := strconv.ParseInt(client.restartMarker, 10, 64); parseErr == nil && client.binary() {
+			offset = marker
+		}
+		return err
+	})
+}
+
+// Passes the offset an attempt of a file starts at to onAttempt, if it is set.
+func (client *clientImpl) attempt(offset int64) {
+	if client.onAttempt != nil {
+		client.onAttempt(offset)
+	}
+}
+
+func (client *clientImpl) binary() bool {
+	return client.type_ == TypeBinary || client.type_ == TypeLocal
+}
@@ -0,0 +1,139 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRetry(t *testing.T) {
+	os.Mkdir("_test_", 0777)
+	defer os.RemoveAll("_test_")
+
+	file := bytes.Repeat([]byte("0123456789"), 100)
+	lines := make(chan string, 64)
+	listener, _ := net.Listen("tcp", ":8987")
+	defer listener.Close()
+	go func() {
+		retrieves, sizes := 0, 0
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server := textproto.NewConn(conn)
+
+			var dataConn net.Conn
+			offset := 0
+			server.Writer.PrintfLine("220 Service ready for new user.")
+			for {
+				line, err := server.ReadLine()
+				if err != nil {
+					break
+				}
+				lines <- line
+				command := strings.SplitN(line, " ", 2)
+				switch command[0] {
+				case "USER":
+					server.Writer.PrintfLine("331 User name okay, need password.")
+				case "PASS":
+					server.Writer.PrintfLine("230 User logged in, proceed.")
+				case "PORT":
+					var h1, h2, h3, h4, p1, p2 byte
+					fmt.Sscanf(line, "PORT %d,%d,%d,%d,%d,%d", &h1, &h2, &h3, &h4, &p1, &p2)
+					dataConn, _ = net.Dial("tcp", fmt.Sprintf("%d.%d.%d.%d:%d", h1, h2, h3, h4, int(p1)*256+int(p2)))
+					server.Writer.PrintfLine("200 Command okay.")
+				case "REST":
+					fmt.Sscanf(command[1], "%d", &offset)
+					server.Writer.PrintfLine("350 Requested file action pending further information.")
+				case "SIZE":
+					// Busy once, and the missing file is never found.
+					sizes++
+					if command[1] == "none" {
+						server.Writer.PrintfLine("550 File unavailable.")
+					} else if sizes == 1 {
+						server.Writer.PrintfLine("450 File busy.")
+					} else {
+						server.Writer.PrintfLine("213 %d", len(file))
+					}
+				case "RETR":
+					// The first transfer is cut off with the control
+					// connection after 300 bytes.
+					retrieves++
+					server.Writer.PrintfLine("125 Data connection already open; transfer starting.")
+					if retrieves == 1 {
+						dataConn.Write(file[:300])
+						dataConn.Close()
+						conn.Close()
+						break
+					}
+					dataConn.Write(file[offset:])
+					dataConn.Close()
+					offset = 0
+					server.Writer.PrintfLine("250 Requested file action okay, completed.")
+				default:
+					server.Writer.PrintfLine("200 Command okay.")
+				}
+			}
+			server.Close()
+		}
+	}()
+
+	client, err := NewFtpClient("localhost:8987")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Login("user", "password")
+	client.Type(TypeBinary)
+	client.SetRetryPolicy(3, 1)
+
+	if size, err := client.Size("file"); err != nil || size != int64(len(file)) {
+		t.Fatalf("got %d, %v", size, err)
+	}
+	if _, err := client.Size("none"); err == nil || err.(*ReplyError).Code != 550 {
+		t.Fatalf("got %v", err)
+	}
+
+	for len(lines) > 0 {
+		<-lines
+	}
+	if err := client.Retrieve("_test_/file", "file"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile("_test_/file"); !bytes.Equal(data, file) {
+		t.Fatal("file not equal")
+	}
+
+	// The session is restored, and the transfer resumed where it broke.
+	var got []string
+	for len(lines) > 0 {
+		if line := <-lines; !strings.HasPrefix(line, "PORT") {
+			got = append(got, line)
+		}
+	}
+	want := []string{"RETR file", "FEAT", "USER user", "PASS password", "TYPE I", "REST 300", "RETR file"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got %q", got)
+	}
+}
+
+// A transfer which fails before it starts leaves no marker of the file before,
+// where a retry would resume.
+func TestRetryStaleMarker(t *testing.T) {
+	defer os.RemoveAll("_test_")
+	client := defaultFtpClient()
+	client.connMode = 0xff
+
+	client.restartMarker = "4096"
+	if err := client.storeFile("test_files/small9993", "file", 0); err != ErrConnModeNotSupported || client.GetRestartMarker() != "" {
+		t.Fatalf("got %v, marker %q", err, client.GetRestartMarker())
+	}
+	client.restartMarker = "4096"
+	if err := client.retrieve("_test_/file", "file", 0); err != ErrConnModeNotSupported || client.GetRestartMarker() != "" {
+		t.Fatalf("got %v, marker %q", err, client.GetRestartMarker())
+	}
+}
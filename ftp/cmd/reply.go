@@ -7,9 +7,9 @@ const (
 	ABOUT_TO_DATA_CONN        = 150
 	OK                        = 200
 	_                         = 202
-	_                         = 211
+	StatusSystemStatus        = 211
 	_                         = 212
-	_                         = 213
+	StatusFileStatus          = 213
 	_                         = 214
 	_                         = 215
 	SERVICE_READY             = 220
@@ -17,12 +17,13 @@ const (
 	_                         = 225
 	_                         = 226
 	StatusEnteringPasvMode    = 227
+	StatusEnteringEpsvMode    = 229
 	LOGIN_PROCEED             = 230
 	StatusFileActionCompleted = 250
 	_                         = 257
 	USERNAME_OK               = 331
 	NEED_ACCOUNT              = 332
-	_                         = 350
+	StatusFileActionPending   = 350
 	NOT_AVAILABLE             = 421
 	_                         = 425
 	_                         = 426
@@ -31,7 +32,7 @@ const (
 	_                         = 452
 	SYNTAX_ERROR              = 500
 	SYNTAX_ERROR_IN_PARAM     = 501
-	_                         = 502
+	StatusNotImplemented      = 502
 	BAD_SEQUENCE              = 503
 	StatusParamNotImplemented = 504
 	NOT_LOGIN                 = 530
@@ -40,6 +41,7 @@ const (
 	_                         = 551
 	_                         = 552
 	_                         = 553
+	StatusInvalidRestart      = 554
 )
 
 var codeMessages = map[int]string{
@@ -0,0 +1,146 @@
+// Package compressed implements the compressed transmission mode of RFC 959.
+//
+// The data is sent as a sequence of headers each followed by its bytes:
+//
+//	0nnnnnnn <n bytes>    n bytes of regular data
+//	10nnnnnn <1 byte>     the byte replicated n times
+//	11nnnnnn              n filler bytes
+//	00000000 <descriptor> escape sequence, a descriptor as in block mode
+//
+// As in block mode, the end of a file is marked by the EOF descriptor, so the
+// data connection can be shared by several files.
+package compressed
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+const (
+	DescriptorEOR     byte = 0x80 // end of record
+	DescriptorEOF     byte = 0x40 // end of file
+	DescriptorSuspect byte = 0x20 // suspected errors in data
+	DescriptorRestart byte = 0x10 // data is a restart marker
+
+	maxRegular    = 1<<7 - 1
+	maxReplicated = 1<<6 - 1
+
+	typeRegular    byte = 0x00
+	typeReplicated byte = 0x80
+	typeFiller     byte = 0xc0
+)
+
+var ErrBrokenData = errors.New("compressed data broken")
+
+// The filler byte is a space for ASCII and EBCDIC, and zero otherwise.
+func Filler(type_ byte) byte {
+	switch type_ {
+	case 'A':
+		return ' '
+	case 'E':
+		return 0x40
+	default:
+		return 0
+	}
+}
+
+// Compresses the data read from src to dst, followed by an EOF descriptor.
+func Send(dst io.Writer, src io.Reader, filler byte) error {
+	w := bufio.NewWriter(dst)
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := io.ReadFull(src, buf)
+		encode(w, buf[:n], filler)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	w.Write([]byte{0, DescriptorEOF})
+	return w.Flush()
+}
+
+func encode(w *bufio.Writer, data []byte, filler byte) {
+	regular := 0 // start of the pending regular data
+	for i := 0; i < len(data); {
+		run := 1
+		for i+run < len(data) && run < maxReplicated && data[i+run] == data[i] {
+			run++
+		}
+
+		// A filler header pays off from 2 bytes on, a replicated one from 3.
+		if (data[i] == filler && run >= 2) || run >= 3 {
+			writeRegular(w, data[regular:i])
+			if data[i] == filler {
+				w.WriteByte(typeFiller | byte(run))
+			} else {
+				w.Write([]byte{typeReplicated | byte(run), data[i]})
+			}
+			i += run
+			regular = i
+		} else {
+			i++
+		}
+	}
+	writeRegular(w, data[regular:])
+}
+
+func writeRegular(w *bufio.Writer, data []byte) {
+	for len(data) > 0 {
+		n := len(data)
+		if n > maxRegular {
+			n = maxRegular
+		}
+		w.WriteByte(typeRegular | byte(n))
+		w.Write(data[:n])
+		data = data[n:]
+	}
+}
+
+// Decompresses the data read from src to dst, until an EOF descriptor.
+// It never reads past the descriptor, so the next file can follow on src.
+func Receive(dst io.Writer, src io.Reader, filler byte) error {
+	header := make([]byte, 1)
+	buf := make([]byte, maxRegular)
+	for {
+		if _, err := io.ReadFull(src, header); err != nil {
+			return ErrBrokenData
+		}
+
+		n := int(header[0] & maxReplicated)
+		switch {
+		case header[0] == 0: // escape sequence
+			if _, err := io.ReadFull(src, header); err != nil {
+				return ErrBrokenData
+			}
+			if header[0]&DescriptorEOF != 0 {
+				return nil
+			}
+			continue
+		case header[0]&0x80 == typeRegular:
+			n = int(header[0])
+			if _, err := io.ReadFull(src, buf[:n]); err != nil {
+				return ErrBrokenData
+			}
+		case header[0]&0xc0 == typeReplicated:
+			if _, err := io.ReadFull(src, header); err != nil {
+				return ErrBrokenData
+			}
+			fill(buf[:n], header[0])
+		default:
+			fill(buf[:n], filler)
+		}
+
+		if _, err := dst.Write(buf[:n]); err != nil {
+			return err
+		}
+	}
+}
+
+func fill(b []byte, c byte) {
+	for i := range b {
+		b[i] = c
+	}
+}
@@ -0,0 +1,72 @@
+package compressed
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	var buf bytes.Buffer
+	Send(&buf, bytes.NewBufferString("abcccc    d"), ' ')
+
+	expect := []byte{
+		0x02, 'a', 'b', // regular
+		0x84, 'c', // replicated
+		0xc4,      // filler
+		0x01, 'd', // regular
+		0x00, DescriptorEOF,
+	}
+	if !bytes.Equal(buf.Bytes(), expect) {
+		t.Fatalf("got % x\nwant % x", buf.Bytes(), expect)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	random := make([]byte, 100<<10)
+	rand.Read(random)
+
+	files := [][]byte{
+		{},
+		[]byte("x"),
+		bytes.Repeat([]byte{0}, 1000),
+		bytes.Repeat([]byte("aaaaaaaab  "), 5000),
+		random,
+	}
+
+	// All files share one stream.
+	var conn bytes.Buffer
+	for _, file := range files {
+		if err := Send(&conn, bytes.NewReader(file), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, file := range files {
+		var received bytes.Buffer
+		if err := Receive(&received, &conn, 0); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(received.Bytes(), file) {
+			t.Fatalf("file %d not equal", i)
+		}
+	}
+	if conn.Len() != 0 {
+		t.Fatalf("%d bytes left", conn.Len())
+	}
+}
+
+func TestCompression(t *testing.T) {
+	var conn bytes.Buffer
+	file := bytes.Repeat([]byte("aaaaaaaab  "), 5000)
+	Send(&conn, bytes.NewReader(file), ' ')
+	if conn.Len() >= len(file)/2 {
+		t.Fatalf("%d bytes compressed to %d", len(file), conn.Len())
+	}
+}
+
+func TestBroken(t *testing.T) {
+	if err := Receive(&bytes.Buffer{}, bytes.NewReader([]byte{0x05, 'a'}), 0); err != ErrBrokenData {
+		t.Fatal("truncated data should be broken")
+	}
+}
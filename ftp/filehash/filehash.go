@@ -0,0 +1,45 @@
+// Package filehash holds the algorithms of the whole-file digests of HASH, as
+// in draft-bryan-ftpext-hash, so that the client and the server agree on their
+// names.
+package filehash
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"hash/crc32"
+)
+
+const Default = "SHA-256"
+
+// The algorithms supported, in the order listed by FEAT.
+var Algorithms = []string{"SHA-1", "SHA-256", "SHA-512", "MD5", "CRC32"}
+
+// The commands of a single algorithm, which reply only the digest. They are
+// older than HASH, and used when a server has no HASH.
+var Commands = map[string]string{
+	"CRC32":   "XCRC",
+	"MD5":     "XMD5",
+	"SHA-256": "XSHA256",
+}
+
+// Returns a hash of the algorithm by its name in upper case, or nil if it is
+// not supported.
+func New(algorithm string) hash.Hash {
+	switch algorithm {
+	case "SHA-1":
+		return sha1.New()
+	case "SHA-256":
+		return sha256.New()
+	case "SHA-512":
+		return sha512.New()
+	case "MD5":
+		return md5.New()
+	case "CRC32":
+		return crc32.NewIEEE()
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,24 @@
+package filehash
+
+import "testing"
+
+func TestAlgorithms(t *testing.T) {
+	supported := make(map[string]bool)
+	for _, algorithm := range Algorithms {
+		if New(algorithm) == nil {
+			t.Errorf("%s listed but not supported", algorithm)
+		}
+		supported[algorithm] = true
+	}
+	if !supported[Default] {
+		t.Errorf("default %s not listed", Default)
+	}
+	for algorithm, command := range Commands {
+		if !supported[algorithm] {
+			t.Errorf("%s of %s not listed", algorithm, command)
+		}
+	}
+	if New("sha-256") != nil || New("SHA-3") != nil {
+		t.Error("names are upper case and known")
+	}
+}
@@ -0,0 +1,140 @@
+package rate
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Reads and writes are split into chunks of at most this size, so that a
+// large buffer does not pass in one burst.
+const chunkSize = 16 << 10
+
+// A token bucket limiting the number of bytes per second. The bucket holds at
+// most one second of tokens. A nil Limiter or a rate of 0 is unlimited.
+// A Limiter can be shared by several connections.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+func NewLimiter(bytesPerSecond int64) *Limiter {
+	return &Limiter{rate: bytesPerSecond, last: time.Now()}
+}
+
+func (l *Limiter) SetRate(bytesPerSecond int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = bytesPerSecond
+}
+
+func (l *Limiter) Rate() int64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// Take n tokens, and block until the bucket is no longer in debt.
+func (l *Limiter) Wait(n int) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	time.Sleep(wait)
+}
+
+func wait(limiters []*Limiter, n int) {
+	for _, l := range limiters {
+		l.Wait(n)
+	}
+}
+
+type reader struct {
+	r        io.Reader
+	limiters []*Limiter
+}
+
+// Returns a Reader whose throughput is limited by all the limiters.
+func NewReader(r io.Reader, limiters ...*Limiter) io.Reader {
+	return &reader{r, limiters}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > chunkSize {
+		p = p[:chunkSize]
+	}
+	n, err := r.r.Read(p)
+	wait(r.limiters, n)
+	return n, err
+}
+
+type writer struct {
+	w        io.Writer
+	limiters []*Limiter
+}
+
+// Returns a Writer whose throughput is limited by all the limiters.
+func NewWriter(w io.Writer, limiters ...*Limiter) io.Writer {
+	return &writer{w, limiters}
+}
+
+func (w *writer) Write(p []byte) (written int, err error) {
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		wait(w.limiters, len(chunk))
+
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+type conn struct {
+	net.Conn
+	r io.Reader
+	w io.Writer
+}
+
+// Returns a Conn whose throughput in each direction is limited by all the limiters.
+func NewConn(c net.Conn, limiters ...*Limiter) net.Conn {
+	return &conn{c, NewReader(c, limiters...), NewWriter(c, limiters...)}
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	return c.w.Write(p)
+}
@@ -0,0 +1,55 @@
+package rate
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	data := make([]byte, 50<<10)
+
+	t.Run("reader", func(t *testing.T) {
+		start := time.Now()
+		io.Copy(ioutil.Discard, NewReader(bytes.NewReader(data), NewLimiter(100<<10)))
+		if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+			t.Fatalf("50KiB at 100KiB/s took %s", elapsed)
+		}
+	})
+
+	t.Run("writer", func(t *testing.T) {
+		start := time.Now()
+		NewWriter(ioutil.Discard, NewLimiter(100<<10)).Write(data)
+		if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+			t.Fatalf("50KiB at 100KiB/s took %s", elapsed)
+		}
+	})
+
+	t.Run("shared", func(t *testing.T) {
+		limiter := NewLimiter(200 << 10)
+		start := time.Now()
+		done := make(chan bool)
+		for i := 0; i < 2; i++ {
+			go func() {
+				io.Copy(ioutil.Discard, NewReader(bytes.NewReader(data), limiter))
+				done <- true
+			}()
+		}
+		<-done
+		<-done
+		if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+			t.Fatalf("2*50KiB at 200KiB/s took %s", elapsed)
+		}
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		var limiter *Limiter
+		start := time.Now()
+		io.Copy(ioutil.Discard, NewReader(bytes.NewReader(data), limiter, NewLimiter(0)))
+		if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+			t.Fatalf("unlimited copy took %s", elapsed)
+		}
+	})
+}
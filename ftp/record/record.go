@@ -0,0 +1,146 @@
+// Package record implements the record structure of RFC 959 in stream mode.
+//
+// The end of a record and the end of the file are marked by escape sequences,
+// a data byte of all ones is sent twice:
+//
+//	0xff 0x01 end of record
+//	0xff 0x02 end of file
+//	0xff 0x03 end of record and end of file
+//	0xff 0xff a data byte 0xff
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+const (
+	Escape byte = 0xff
+
+	EOR byte = 0x01 // end of record
+	EOF byte = 0x02 // end of file
+)
+
+var ErrBrokenRecord = errors.New("record broken")
+
+// A sequence of records. ReadRecord returns io.EOF after the last record.
+type Reader interface {
+	ReadRecord() ([]byte, error)
+}
+
+type Writer interface {
+	WriteRecord(record []byte) error
+}
+
+// Returns a Reader of the lines read from r, each line without the eol is a
+// record. A last line without the eol is a record as well.
+func NewLineReader(r io.Reader, eol []byte) Reader {
+	return &lineReader{r: bufio.NewReader(r), eol: eol}
+}
+
+type lineReader struct {
+	r   *bufio.Reader
+	eol []byte
+}
+
+func (r *lineReader) ReadRecord() ([]byte, error) {
+	last := r.eol[len(r.eol)-1]
+	var line []byte
+	for {
+		b, err := r.r.ReadBytes(last)
+		line = append(line, b...)
+		if err == io.EOF {
+			if len(line) == 0 {
+				return nil, io.EOF
+			}
+			return line, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if bytes.HasSuffix(line, r.eol) {
+			return line[:len(line)-len(r.eol)], nil
+		}
+	}
+}
+
+// Returns a Writer which writes each record to w followed by the eol.
+func NewLineWriter(w io.Writer, eol []byte) Writer {
+	return &lineWriter{w: w, eol: eol}
+}
+
+type lineWriter struct {
+	w   io.Writer
+	eol []byte
+}
+
+func (w *lineWriter) WriteRecord(record []byte) error {
+	if _, err := w.w.Write(record); err != nil {
+		return err
+	}
+	_, err := w.w.Write(w.eol)
+	return err
+}
+
+// Sends the records read from src to dst in stream mode, followed by an end
+// of file.
+func Send(dst io.Writer, src Reader) error {
+	w := bufio.NewWriter(dst)
+	for {
+		record, err := src.ReadRecord()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		for _, b := range record {
+			if b == Escape {
+				w.WriteByte(Escape)
+			}
+			w.WriteByte(b)
+		}
+		w.Write([]byte{Escape, EOR})
+	}
+	w.Write([]byte{Escape, EOF})
+	return w.Flush()
+}
+
+// Receives the records in stream mode from src to dst, until an end of file.
+// Data after the last end of record is a record as well.
+func Receive(dst Writer, src io.Reader) error {
+	r := bufio.NewReader(src)
+	var record []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return ErrBrokenRecord
+		}
+		if b != Escape {
+			record = append(record, b)
+			continue
+		}
+
+		if b, err = r.ReadByte(); err != nil {
+			return ErrBrokenRecord
+		}
+		if b == Escape {
+			record = append(record, b)
+			continue
+		}
+		if b&^(EOR|EOF) != 0 {
+			return ErrBrokenRecord
+		}
+
+		if b&EOR != 0 || (b&EOF != 0 && len(record) > 0) {
+			if err := dst.WriteRecord(record); err != nil {
+				return err
+			}
+			record = nil
+		}
+		if b&EOF != 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package record
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+type records [][]byte
+
+func (r *records) ReadRecord() ([]byte, error) {
+	if len(*r) == 0 {
+		return nil, io.EOF
+	}
+	rec := (*r)[0]
+	*r = (*r)[1:]
+	return rec, nil
+}
+
+func (r *records) WriteRecord(record []byte) error {
+	*r = append(*r, record)
+	return nil
+}
+
+func TestEncode(t *testing.T) {
+	var buf bytes.Buffer
+	Send(&buf, &records{[]byte("ab"), {}, {0xff}})
+
+	expect := []byte{'a', 'b', 0xff, EOR, 0xff, EOR, 0xff, 0xff, 0xff, EOR, 0xff, EOF}
+	if !bytes.Equal(buf.Bytes(), expect) {
+		t.Fatalf("got % x\nwant % x", buf.Bytes(), expect)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	cases := []struct {
+		data   []byte
+		expect records
+	}{
+		{[]byte{0xff, EOF}, nil},
+		{[]byte{'a', 0xff, EOR, 0xff, EOF}, records{[]byte("a")}},
+		{[]byte{'a', 0xff, EOR | EOF}, records{[]byte("a")}},
+		// Data before the end of file without an end of record.
+		{[]byte{'a', 0xff, EOR, 'b', 0xff, EOF}, records{[]byte("a"), []byte("b")}},
+		{[]byte{0xff, 0xff, 0xff, EOR, 0xff, EOF}, records{{0xff}}},
+	}
+
+	for _, c := range cases {
+		var received records
+		if err := Receive(&received, bytes.NewReader(c.data)); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprintf("%q", received) != fmt.Sprintf("%q", c.expect) {
+			t.Errorf("decode % x: got %q, want %q", c.data, received, c.expect)
+		}
+	}
+
+	if err := Receive(&records{}, bytes.NewReader([]byte{'a', 0xff, EOR})); err != ErrBrokenRecord {
+		t.Error("data without end of file should be broken")
+	}
+	if err := Receive(&records{}, bytes.NewReader([]byte{0xff, 0x10})); err != ErrBrokenRecord {
+		t.Error("unknown escape sequence should be broken")
+	}
+}
+
+func TestLines(t *testing.T) {
+	cases := []struct {
+		file   string
+		expect records
+	}{
+		{"", nil},
+		{"a\r\nb\r\n", records{[]byte("a"), []byte("b")}},
+		{"a\r\n\r\nlast", records{[]byte("a"), {}, []byte("last")}},
+		{"bare\nlf\r\n", records{[]byte("bare\nlf")}},
+	}
+
+	for _, c := range cases {
+		r := NewLineReader(bytes.NewBufferString(c.file), []byte("\r\n"))
+		var read records
+		for {
+			rec, err := r.ReadRecord()
+			if err == io.EOF {
+				break
+			}
+			read = append(read, rec)
+		}
+		if fmt.Sprintf("%q", read) != fmt.Sprintf("%q", c.expect) {
+			t.Errorf("lines of %q: got %q, want %q", c.file, read, c.expect)
+		}
+	}
+
+	var file bytes.Buffer
+	w := NewLineWriter(&file, []byte("\r\n"))
+	w.WriteRecord([]byte("a"))
+	w.WriteRecord([]byte{})
+	if file.String() != "a\r\n\r\n" {
+		t.Errorf("got %q", file.String())
+	}
+}
@@ -0,0 +1,180 @@
+// Package repr converts files between their local representation and the
+// representation type used on the data connection.
+package repr
+
+import (
+	"io"
+	"runtime"
+)
+
+const (
+	TypeAscii  byte = 'A'
+	TypeEbcdic byte = 'E'
+	TypeBinary byte = 'I'
+	// TYPE L 8, which is the same as binary.
+	TypeLocal byte = 'L'
+)
+
+// Line ending of local text files.
+var LocalNewline = func() []byte {
+	if runtime.GOOS == "windows" {
+		return []byte("\r\n")
+	}
+	return []byte("\n")
+}()
+
+// The end of line on the data connection in the representation type type_.
+func Newline(type_ byte) []byte {
+	switch type_ {
+	case TypeAscii:
+		return []byte("\r\n")
+	case TypeEbcdic:
+		return []byte{ebcdicNewline}
+	default:
+		return []byte("\n")
+	}
+}
+
+// Returns a Reader which converts the local file read from r to the
+// representation type type_.
+func NewReader(r io.Reader, type_ byte) io.Reader {
+	switch type_ {
+	case TypeAscii:
+		return &transformReader{r: r, t: &asciiEncoder{}, buf: make([]byte, 4096)}
+	case TypeEbcdic:
+		return &transformReader{r: r, t: &ebcdicEncoder{}, buf: make([]byte, 4096)}
+	default:
+		return r
+	}
+}
+
+// Returns a Writer which converts data of the representation type type_ to
+// the local file written to w. Close flushes the conversion, but does not
+// close w.
+func NewWriter(w io.Writer, type_ byte) io.WriteCloser {
+	switch type_ {
+	case TypeAscii:
+		return &transformWriter{w: w, t: &asciiDecoder{}}
+	case TypeEbcdic:
+		return &transformWriter{w: w, t: ebcdicDecoder{}}
+	default:
+		return nopCloser{w}
+	}
+}
+
+// Counts the bytes of the local file read from r in the representation type type_.
+func Size(r io.Reader, type_ byte) (int64, error) {
+	return io.Copy(io.Discard, NewReader(r, type_))
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// A stateful conversion, which appends the conversion of src to dst. At the
+// end of data flush is called to append what is left.
+type transformer interface {
+	transform(dst, src []byte) []byte
+	flush(dst []byte) []byte
+}
+
+type transformReader struct {
+	r       io.Reader
+	t       transformer
+	buf     []byte
+	pending []byte
+	err     error
+}
+
+func (r *transformReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		n, err := r.r.Read(r.buf)
+		r.pending = r.t.transform(r.pending[:0], r.buf[:n])
+		if err != nil {
+			if err == io.EOF {
+				r.pending = r.t.flush(r.pending)
+			}
+			r.err = err
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+type transformWriter struct {
+	w   io.Writer
+	t   transformer
+	buf []byte
+}
+
+func (w *transformWriter) Write(p []byte) (int, error) {
+	w.buf = w.t.transform(w.buf[:0], p)
+	if _, err := w.w.Write(w.buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *transformWriter) Close() error {
+	w.buf = w.t.flush(w.buf[:0])
+	_, err := w.w.Write(w.buf)
+	return err
+}
+
+// Converts local line endings to CRLF. A CRLF in the local file is kept.
+type asciiEncoder struct {
+	cr bool
+}
+
+func (e *asciiEncoder) transform(dst, src []byte) []byte {
+	for _, b := range src {
+		if b == '\n' && !e.cr {
+			dst = append(dst, '\r')
+		}
+		dst = append(dst, b)
+		e.cr = b == '\r'
+	}
+	return dst
+}
+
+func (e *asciiEncoder) flush(dst []byte) []byte {
+	return dst
+}
+
+// Converts CRLF to local line endings. A CR which is not followed by a LF is kept.
+type asciiDecoder struct {
+	cr bool
+}
+
+func (d *asciiDecoder) transform(dst, src []byte) []byte {
+	for _, b := range src {
+		if d.cr {
+			d.cr = false
+			if b == '\n' {
+				dst = append(dst, LocalNewline...)
+				continue
+			}
+			dst = append(dst, '\r')
+		}
+		if b == '\r' {
+			d.cr = true
+		} else {
+			dst = append(dst, b)
+		}
+	}
+	return dst
+}
+
+func (d *asciiDecoder) flush(dst []byte) []byte {
+	if d.cr {
+		d.cr = false
+		dst = append(dst, '\r')
+	}
+	return dst
+}
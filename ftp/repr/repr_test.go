@@ -0,0 +1,97 @@
+package repr
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestAscii(t *testing.T) {
+	LocalNewline = []byte("\n")
+
+	cases := []struct {
+		local, network string
+	}{
+		{"", ""},
+		{"line\n", "line\r\n"},
+		{"a\nb\n\nc", "a\r\nb\r\n\r\nc"},
+		{"dos\r\nline\r\n", "dos\r\nline\r\n"},
+		{"bare\rcr\r", "bare\rcr\r"},
+	}
+
+	for _, c := range cases {
+		// One byte a time, so the conversion has to keep state between reads.
+		encoded, err := io.ReadAll(NewReader(iotest.OneByteReader(bytes.NewBufferString(c.local)), TypeAscii))
+		if err != nil || string(encoded) != c.network {
+			t.Errorf("encode %q: got %q, want %q", c.local, encoded, c.network)
+		}
+
+		if size, _ := Size(bytes.NewBufferString(c.local), TypeAscii); size != int64(len(c.network)) {
+			t.Errorf("size of %q: got %d, want %d", c.local, size, len(c.network))
+		}
+
+		var decoded bytes.Buffer
+		w := NewWriter(&decoded, TypeAscii)
+		for i := range c.network {
+			w.Write([]byte(c.network[i : i+1]))
+		}
+		w.Close()
+		if want := bytes.ReplaceAll([]byte(c.local), []byte("\r\n"), []byte("\n")); !bytes.Equal(decoded.Bytes(), want) {
+			t.Errorf("decode %q: got %q, want %q", c.network, decoded.Bytes(), want)
+		}
+	}
+}
+
+func TestBinary(t *testing.T) {
+	data := []byte("a\nb\r\n\xff")
+	encoded, _ := io.ReadAll(NewReader(bytes.NewReader(data), TypeBinary))
+	if !bytes.Equal(encoded, data) {
+		t.Errorf("binary should not be converted: %q", encoded)
+	}
+
+	var decoded bytes.Buffer
+	w := NewWriter(&decoded, TypeBinary)
+	w.Write(data)
+	w.Close()
+	if !bytes.Equal(decoded.Bytes(), data) {
+		t.Errorf("binary should not be converted: %q", decoded.Bytes())
+	}
+}
+
+func TestEbcdic(t *testing.T) {
+	LocalNewline = []byte("\n")
+
+	local := "HELLO, world 0123!\n"
+	network := []byte{
+		0xc8, 0xc5, 0xd3, 0xd3, 0xd6, 0x6b, 0x40, 0xa6, 0x96, 0x99, 0x93, 0x84,
+		0x40, 0xf0, 0xf1, 0xf2, 0xf3, 0x5a, 0x15,
+	}
+
+	encoded, _ := io.ReadAll(NewReader(bytes.NewBufferString(local), TypeEbcdic))
+	if !bytes.Equal(encoded, network) {
+		t.Errorf("encode %q: got % x", local, encoded)
+	}
+
+	var decoded bytes.Buffer
+	w := NewWriter(&decoded, TypeEbcdic)
+	w.Write(network)
+	w.Close()
+	if decoded.String() != local {
+		t.Errorf("decode: got %q, want %q", decoded.String(), local)
+	}
+
+	// Every byte survives a round trip.
+	all := make([]byte, 256)
+	for i := range all {
+		all[i] = byte(i)
+	}
+	encoded, _ = io.ReadAll(NewReader(bytes.NewReader(all), TypeEbcdic))
+	decoded.Reset()
+	w = NewWriter(&decoded, TypeEbcdic)
+	w.Write(encoded)
+	w.Close()
+	if !bytes.Equal(decoded.Bytes(), all) {
+		t.Errorf("round trip: got % x", decoded.Bytes())
+	}
+}
@@ -30,13 +30,30 @@ func (c *clientHandler) handleUSER(param string) error {
 }
 
 func (c *clientHandler) handlePASS(param string) error {
+	// The IP may have been banned by another of its sessions.
+	if c.server.isBanned(c.ip) {
+		logger.Printf("%s:%s rejected: banned", c.ip, c.username)
+		c.reply(StatusServiceNotAvailable, "too many failed logins")
+		return ErrCloseConn
+	}
+
 	if password := account[c.username]; password == param {
+		if !c.login && !c.server.acquireUser(c.username) {
+			logger.Printf("%s rejected: too many connections", c.username)
+			c.reply(StatusServiceNotAvailable, "too many connections")
+			return ErrCloseConn
+		}
 		c.login = true
+		c.server.loginSucceeded(c.ip)
 		return c.reply(StatuLoginProceed)
 	} else {
+		if c.login {
+			c.server.releaseUser(c.username)
+		}
+		username := c.username
 		c.login = false
 		c.username = ""
-		return c.reply(StatusNotLoggedIn)
+		return c.loginFailed(username)
 	}
 }
 
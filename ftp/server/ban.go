@@ -0,0 +1,117 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxLoginAttempts  = 5
+	DefaultLoginFailureDelay = 500 * time.Millisecond
+	DefaultBanDuration       = 10 * time.Minute
+)
+
+// Remote IPs which are refused until the ban expires, and the failed logins
+// of each IP, over all its sessions.
+type banList struct {
+	sync.Mutex
+	until    map[string]time.Time
+	failures map[string]*loginFailures
+}
+
+// Failed logins are forgotten once none has followed for as long as a ban, or
+// the IP logs in.
+type loginFailures struct {
+	n    int
+	last time.Time
+}
+
+func (server *_ServerImpl) isBanned(ip string) bool {
+	bans := &server.bans
+	bans.Lock()
+	defer bans.Unlock()
+
+	until, has := bans.until[ip]
+	if !has {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(bans.until, ip)
+		logger.Printf("%s ban expired", ip)
+		return false
+	}
+	return true
+}
+
+func (server *_ServerImpl) ban(ip string) {
+	if server.banDuration <= 0 {
+		return
+	}
+
+	bans := &server.bans
+	bans.Lock()
+	defer bans.Unlock()
+
+	if bans.until == nil {
+		bans.until = make(map[string]time.Time)
+	}
+	bans.until[ip] = time.Now().Add(server.banDuration)
+	logger.Printf("%s banned for %s", ip, server.banDuration)
+}
+
+// Counts a failed login of the IP, and returns the failures not yet
+// forgotten.
+func (server *_ServerImpl) loginFailure(ip string) int {
+	expiry := server.banDuration
+	if expiry <= 0 {
+		expiry = DefaultBanDuration
+	}
+
+	bans := &server.bans
+	bans.Lock()
+	defer bans.Unlock()
+
+	if bans.failures == nil {
+		bans.failures = make(map[string]*loginFailures)
+	}
+	now := time.Now()
+	for other, f := range bans.failures {
+		if now.Sub(f.last) > expiry {
+			delete(bans.failures, other)
+		}
+	}
+	f, has := bans.failures[ip]
+	if !has {
+		f = &loginFailures{}
+		bans.failures[ip] = f
+	}
+	f.n++
+	f.last = now
+	return f.n
+}
+
+// Forgets the failed logins of the IP once it has logged in.
+func (server *_ServerImpl) loginSucceeded(ip string) {
+	bans := &server.bans
+	bans.Lock()
+	defer bans.Unlock()
+
+	delete(bans.failures, ip)
+}
+
+// Called after each failed PASS. The reply is delayed longer on every failure
+// of the IP, and once the IP runs out of attempts it is banned, so that
+// reconnecting does not start over.
+func (c *clientHandler) loginFailed(username string) error {
+	failures := c.server.loginFailure(c.ip)
+	logger.Printf("%s:%s login failed (%d)", c.ip, username, failures)
+
+	time.Sleep(time.Duration(failures) * c.server.loginFailureDelay)
+
+	if c.server.maxLoginAttempts > 0 && failures >= c.server.maxLoginAttempts {
+		c.server.ban(c.ip)
+		c.reply(StatusServiceNotAvailable, "too many failed logins")
+		return ErrCloseConn
+	}
+	return c.reply(StatusNotLoggedIn)
+}
@@ -1,10 +1,14 @@
 package server
 
 import (
+	"compress/zlib"
+	"ftp/block"
+	"ftp/rate"
 	"io"
 	"net"
 	"net/textproto"
 	"strings"
+	"time"
 )
 
 type clientHandler struct {
@@ -13,15 +17,34 @@ type clientHandler struct {
 
 	login    bool
 	username string
+	ip       string
 
 	mode  byte
 	type_ byte
 	stru  byte
 
+	deflateLevel int          // of MODE Z
+	blockConfig  block.Config // of MODE B
+	restart      int64        // offset of the next transfer, set by REST
+	rangeEnd     int64        // end of the next transfer after restart, set by RANG
+	repair       *uploadRepair
+	lastRETR     string // path of the last RETR, whose blocks XBLK resends
+	pipeline     bool   // files of STOR are sent without waiting for the reply
+	epsvAll      bool   // only EPSV opens data connections, set by EPSV ALL
+
+	hashAlgorithm string // of HASH
+
 	rootDir string
+	server  *_ServerImpl
+
+	idleTimeout   time.Duration
+	dataTimeout   time.Duration
+	loginDeadline time.Time
+
+	limiter *rate.Limiter // bandwidth of this session
 }
 
-func handleClient(conn net.Conn, rootDir string) {
+func handleClient(conn net.Conn, server *_ServerImpl) {
 	defer conn.Close()
 	handler := &clientHandler{
 		ctrl: textproto.NewConn(conn),
@@ -30,17 +53,61 @@ func handleClient(conn net.Conn, rootDir string) {
 		type_: TypeAscii,
 		stru:  StruFile,
 
-		rootDir: rootDir,
+		deflateLevel: zlib.DefaultCompression,
+		blockConfig:  block.Config{RestartInterval: server.restartInterval},
+
+		hashAlgorithm: DefaultHashAlgorithm,
+
+		rootDir: server.rootDir,
+		server:  server,
+
+		idleTimeout: server.idleTimeout,
+		dataTimeout: server.dataTimeout,
+
+		limiter: rate.NewLimiter(server.sessionRate),
+	}
+	// Each restart marker received in a STOR is acknowledged.
+	handler.blockConfig.OnRestart = func(marker string) {
+		handler.reply(StatusRestartMarker, marker, marker)
 	}
+	if server.loginTimeout > 0 {
+		handler.loginDeadline = time.Now().Add(server.loginTimeout)
+	}
+
+	ip := remoteIP(conn)
+	handler.ip = ip
+	if server.isBanned(ip) {
+		logger.Printf("%s rejected: banned", conn.RemoteAddr())
+		handler.reply(StatusServiceNotAvailable, "too many failed logins")
+		return
+	}
+	if !server.acquireSession(ip) {
+		logger.Printf("%s rejected: too many connections", conn.RemoteAddr())
+		handler.reply(StatusServiceNotAvailable, "too many connections")
+		return
+	}
+	defer server.releaseSession(ip)
+	defer func() {
+		if handler.login {
+			server.releaseUser(handler.username)
+		}
+	}()
+	defer handler.abortRepair()
+	defer handler.closeDataConn()
 
 	handler.reply(StatusReady)
 
 	for {
+		conn.SetReadDeadline(handler.readDeadline())
 		cmd, err := handler.ctrl.ReadLine()
 		if err != nil {
 			if err == io.EOF {
 				logger.Printf("%s:%s disconnected", conn.RemoteAddr(), handler.username)
 				break
+			} else if isTimeout(err) {
+				logger.Printf("%s:%s %s", conn.RemoteAddr(), handler.username, handler.timeoutReason())
+				handler.reply(StatusServiceNotAvailable, handler.timeoutReason())
+				break
 			} else {
 				logger.Printf("read command error: %v", err)
 				continue
@@ -81,17 +148,34 @@ var commandHandlers = map[string]commandHandler{
 	"USER": (*clientHandler).handleUSER,
 	"PASS": (*clientHandler).handlePASS,
 	"QUIT": (*clientHandler).handleQUIT,
+	"NOOP": (*clientHandler).handleNOOP,
 
 	//dial commands
 	"PORT": (*clientHandler).handlePORT,
 	"PASV": (*clientHandler).handlePASV,
+	"EPSV": (*clientHandler).handleEPSV,
 
 	//file commands
-	"RETR": (*clientHandler).handleRETR,
-	"STOR": (*clientHandler).handleSTOR,
+	"RETR":    (*clientHandler).handleRETR,
+	"STOR":    (*clientHandler).handleSTOR,
+	"XBLK":    (*clientHandler).handleXBLK,
+	"REST":    (*clientHandler).handleREST,
+	"RANG":    (*clientHandler).handleRANG,
+	"SIZE":    (*clientHandler).handleSIZE,
+	"HASH":    (*clientHandler).handleHASH,
+	"XCRC":    (*clientHandler).handleXCRC,
+	"XMD5":    (*clientHandler).handleXMD5,
+	"XSHA256": (*clientHandler).handleXSHA256,
 
 	//param commands
 	"MODE": (*clientHandler).handleMODE,
 	"TYPE": (*clientHandler).handleTYPE,
 	"STRU": (*clientHandler).handleSTRU,
+
+	//feature commands
+	"FEAT": (*clientHandler).handleFEAT,
+	"OPTS": (*clientHandler).handleOPTS,
+
+	//site commands
+	"SITE": (*clientHandler).handleSITE,
 }
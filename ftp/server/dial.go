@@ -3,18 +3,24 @@ package server
 import (
 	"errors"
 	"fmt"
+	"ftp/rate"
 	"net"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var (
 	ErrConnectToDataPort                = errors.New("connect to data port failed")
 	_                    commandHandler = (*clientHandler).handlePORT
 	_                    commandHandler = (*clientHandler).handlePASV
+	_                    commandHandler = (*clientHandler).handleEPSV
 )
 
 func (c *clientHandler) handlePORT(param string) error {
+	if c.epsvAll {
+		return c.reply(StatusBadSequence)
+	}
 	parts := strings.Split(param, ",")
 	if len(parts) != 6 {
 		return c.reply(StatusSyntaxErrorInParametersOrArguments)
@@ -28,17 +34,23 @@ func (c *clientHandler) handlePORT(param string) error {
 	}
 	port := (portPart1 << 8) | portPart2
 
-	conn, err := net.Dial("tcp", net.JoinHostPort(ip, strconv.Itoa(port)))
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, strconv.Itoa(port)), c.dataTimeout)
 	if err != nil {
 		return ErrConnectToDataPort
 	}
 
-	c.conn = conn
+	// In block mode one data connection is kept for many files, until the
+	// client opens another.
+	c.closeDataConn()
+	c.conn = c.newDataConn(conn)
 
 	return c.reply(StatusOK)
 }
 
 func (c *clientHandler) handlePASV(param string) error {
+	if c.epsvAll {
+		return c.reply(StatusBadSequence)
+	}
 	listener, err := net.ListenTCP("tcp4", nil)
 	if err != nil {
 		return err
@@ -56,12 +68,81 @@ func (c *clientHandler) handlePASV(param string) error {
 		return err
 	}
 
+	if c.dataTimeout > 0 {
+		listener.SetDeadline(time.Now().Add(c.dataTimeout))
+	}
+	conn, err := listener.Accept()
+	if err != nil {
+		return err
+	}
+
+	c.closeDataConn()
+	c.conn = c.newDataConn(conn)
+
+	return nil
+}
+
+// EPSV[<SP><net-prt>|<SP>ALL]<CRLF>, as in RFC 2428. The reply has only the
+// port, the client connects to the address of the control connection, which
+// works for IPv6 and through NAT as well. 1 is IPv4 and 2 is IPv6. After
+// EPSV ALL, PORT and PASV are refused for the rest of the session.
+func (c *clientHandler) handleEPSV(param string) error {
+	network := "tcp"
+	switch strings.ToUpper(param) {
+	case "":
+	case "1":
+		network = "tcp4"
+	case "2":
+		network = "tcp6"
+	case "ALL":
+		c.epsvAll = true
+		return c.reply(StatusOK)
+	default:
+		return c.reply(StatusNetworkProtocolNotSupported)
+	}
+
+	listener, err := net.ListenTCP(network, nil)
+	if err != nil {
+		return c.reply(StatusNetworkProtocolNotSupported)
+	}
+	defer listener.Close()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	if err := c.reply(StatusEnteringEpsv, port); err != nil {
+		return err
+	}
+
+	if c.dataTimeout > 0 {
+		listener.SetDeadline(time.Now().Add(c.dataTimeout))
+	}
 	conn, err := listener.Accept()
 	if err != nil {
 		return err
 	}
 
-	c.conn = conn
+	c.closeDataConn()
+	c.conn = c.newDataConn(conn)
 
 	return nil
 }
+
+func (c *clientHandler) closeDataConn() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// Wraps a newly established data connection with the session's data timeout
+// and bandwidth limits.
+func (c *clientHandler) newDataConn(conn net.Conn) net.Conn {
+	if c.dataTimeout > 0 {
+		conn = &timeoutConn{conn, c.dataTimeout}
+	}
+
+	limiters := []*rate.Limiter{c.server.limiter, c.limiter}
+	if c.login {
+		limiters = append(limiters, c.server.userLimiter(c.username))
+	}
+	return rate.NewConn(conn, limiters...)
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"ftp/block"
+	"strings"
+)
+
+var (
+	_ commandHandler = (*clientHandler).handleFEAT
+	_ commandHandler = (*clientHandler).handleOPTS
+)
+
+// Extensions listed in the FEAT reply.
+var features = []string{
+	"EPSV",
+	"SIZE",
+	"MODE Z",
+	"MODE B CHECKSUM",
+	"MODE B HASH " + blockHashes(),
+	"RANG STREAM",
+	"REST STREAM",
+	"XBLK",
+	"XCRC",
+	"XMD5",
+	"XSHA256",
+}
+
+// The checksum algorithms of the checksummed framing, separated by ";".
+func blockHashes() string {
+	names := make([]string, len(block.Hashes))
+	for i, h := range block.Hashes {
+		names[i] = h.String()
+	}
+	return strings.Join(names, ";")
+}
+
+func (c *clientHandler) handleFEAT(param string) error {
+	lines := append(append([]string(nil), features...), c.hashFeature())
+	return c.replyMultiline(StatusSystemStatus, "Extensions supported:", lines, "End")
+}
+
+type optsHandler func(c *clientHandler, param string) error
+
+// OPTS<SP><command-name>[<SP><command-options>]<CRLF>
+var optsHandlers = map[string]optsHandler{
+	"MODE": (*clientHandler).handleOptsMODE,
+	"HASH": (*clientHandler).handleOptsHASH,
+}
+
+func (c *clientHandler) handleOPTS(param string) error {
+	part := strings.SplitN(param, " ", 2)
+	if handler, has := optsHandlers[strings.ToUpper(part[0])]; has {
+		if len(part) != 2 {
+			return handler(c, "")
+		}
+		return handler(c, part[1])
+	}
+	return c.reply(StatusCommandNotImplementedForParameter)
+}
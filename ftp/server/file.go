@@ -1,8 +1,12 @@
 package server
 
 import (
+	"compress/zlib"
 	"errors"
 	"ftp/block"
+	"ftp/compressed"
+	"ftp/record"
+	"ftp/repr"
 	"io"
 	"os"
 	"path"
@@ -12,10 +16,14 @@ var (
 	ErrModeNotSupported                = errors.New("mode not supported")
 	_                   commandHandler = (*clientHandler).handleRETR
 	_                   commandHandler = (*clientHandler).handleSTOR
+	_                   commandHandler = (*clientHandler).handleSIZE
 )
 
 func (c *clientHandler) handleRETR(param string) error {
-	file, err := os.Open(path.Join(c.rootDir, param))
+	c.abortRepair()
+	c.lastRETR = path.Join(c.rootDir, param)
+
+	file, err := os.Open(c.lastRETR)
 	if err != nil {
 		logger.Print(err)
 		return c.reply(StatusFileUnavailable)
@@ -26,18 +34,39 @@ func (c *clientHandler) handleRETR(param string) error {
 		return c.reply(StatusFileStatusOK)
 	}
 
+	src := repr.NewReader(file, c.type_)
+	offset, end := c.takeRestart()
+	if offset > 0 {
+		if err := c.skipRestart(file, src, offset); err != nil {
+			logger.Print(err)
+			return c.reply(StatusInvalidRestart)
+		}
+	}
+	if end > 0 {
+		src = io.LimitReader(src, end-offset)
+	}
+
 	c.reply(StatusTransferStarted)
 
-	switch c.mode {
-	case ModeStream:
-		err = c.retrieveStreamMode(file)
-	case ModeBlock:
-		err = c.retrieveBlockMode(file)
-	default:
-		return ErrModeNotSupported
+	if c.stru == StruRecord {
+		err = c.retrieveRecords(record.NewLineReader(src, repr.Newline(c.type_)))
+	} else {
+		switch c.mode {
+		case ModeStream:
+			err = c.retrieveStreamMode(src)
+		case ModeBlock:
+			err = c.retrieveBlockMode(src)
+		case ModeCompressed:
+			err = c.retrieveCompressedMode(src)
+		case ModeDeflate:
+			err = c.retrieveDeflateMode(src)
+		default:
+			return ErrModeNotSupported
+		}
 	}
 	if err != nil {
 		logger.Print(err)
+		c.closeDataConn()
 		return c.reply(StatusRequestedFileActionAborted)
 	}
 
@@ -56,45 +85,209 @@ func (c *clientHandler) retrieveStreamMode(localFile io.Reader) error {
 }
 
 func (c *clientHandler) retrieveBlockMode(localFile io.Reader) error {
-	return block.Send(c.conn, localFile)
+	return c.blockConfig.Send(c.conn, localFile)
+}
+
+// As in block mode, the data connection is kept open after each file.
+func (c *clientHandler) retrieveCompressedMode(localFile io.Reader) error {
+	return compressed.Send(c.conn, localFile, compressed.Filler(c.type_))
+}
+
+// As in stream mode, the end of the zlib stream is followed by closing the
+// data connection.
+func (c *clientHandler) retrieveDeflateMode(localFile io.Reader) error {
+	w, err := zlib.NewWriterLevel(c.conn, c.deflateLevel)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, localFile); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	c.closeDataConn()
+
+	return nil
+}
+
+// In record structure each line of the local file is a record. The end of a
+// record is an escape sequence in stream mode and a descriptor in block mode,
+// the other modes do not support records.
+func (c *clientHandler) retrieveRecords(records record.Reader) error {
+	switch c.mode {
+	case ModeStream:
+		if err := record.Send(c.conn, records); err != nil {
+			return err
+		}
+		c.closeDataConn()
+		return nil
+	case ModeBlock:
+		return c.blockConfig.SendRecords(c.conn, records)
+	default:
+		return ErrModeNotSupported
+	}
 }
 
 func (c *clientHandler) handleSTOR(param string) error {
+	c.abortRepair()
+
+	// The quota is of the user logged in, USER alone is not verified.
+	if !c.login {
+		return c.refuseSTOR(StatusNotLoggedIn)
+	}
+
+	p := path.Join(c.rootDir, param)
+	if err := c.server.checkQuota(c.username, p); err != nil {
+		return c.refuseSTOR(StatusExceededStorageAllocation)
+	}
+
 	if c.conn == nil {
 		return c.reply(StatusFileStatusOK)
 	}
 
-	p := path.Join(c.rootDir, param)
 	if err := os.MkdirAll(path.Dir(p), 0777); err != nil {
 		logger.Print(err)
-		return c.reply(StatusFileUnavailable)
+		return c.refuseSTOR(StatusFileUnavailable)
 	}
 
-	file, err := os.Create(p)
-	if err != nil {
+	// A range set by RANG can not be stored, as uploads never write in place.
+	offset, end := c.takeRestart()
+	if end > 0 {
+		return c.refuseSTOR(StatusCommandNotImplementedForParameter)
+	}
+
+	// The file replaced is not charged while its replacement is uploaded.
+	released := c.server.releaseQuotaFile(c.username, p)
+
+	// Upload to a hidden file and rename it into place when done, so that no
+	// one reads an incomplete file. A restarted upload appends to the partial
+	// file kept from the failed one.
+	var file *os.File
+	var err error
+	if offset > 0 {
+		if file, err = c.resumePartial(p, offset); err != nil {
+			logger.Print(err)
+			c.server.restoreQuotaFile(c.username, p, released)
+			return c.refuseSTOR(StatusInvalidRestart)
+		}
+		// The partial file was given back to the quota when the upload failed.
+		if err := c.server.chargeQuota(c.username, offset); err != nil {
+			file.Close()
+			c.server.restoreQuotaFile(c.username, p, released)
+			return c.refuseSTOR(StatusExceededStorageAllocation)
+		}
+	} else if file, err = c.createPartial(p); err != nil {
 		logger.Print(err)
-		return c.reply(StatusFileUnavailable)
+		c.server.restoreQuotaFile(c.username, p, released)
+		return c.refuseSTOR(StatusFileUnavailable)
 	}
-	defer file.Close()
 
 	c.reply(StatusTransferStarted)
 
-	switch c.mode {
-	case ModeStream:
-		err = c.storeStreamMode(file)
-	case ModeBlock:
-		err = c.storeBlockMode(file)
-	default:
-		return ErrModeNotSupported
+	w := &quotaWriter{w: file, server: c.server, username: c.username}
+	dst := repr.NewWriter(w, c.type_)
+	if c.stru == StruRecord {
+		err = c.storeRecords(record.NewLineWriter(dst, repr.Newline(c.type_)))
+	} else {
+		switch c.mode {
+		case ModeStream:
+			err = c.storeStreamMode(dst)
+		case ModeBlock:
+			err = c.storeBlockMode(dst)
+		case ModeCompressed:
+			err = c.storeCompressedMode(dst)
+		case ModeDeflate:
+			err = c.storeDeflateMode(dst)
+		default:
+			err = ErrModeNotSupported
+		}
+	}
+	if err == nil {
+		err = dst.Close()
+	}
+
+	// The damaged blocks are resent by XBLK before the upload is done.
+	var damaged *block.DamagedError
+	if errors.As(err, &damaged) && c.type_ == TypeBinary {
+		c.repair = &uploadRepair{
+			file:     file,
+			path:     p,
+			released: released,
+			charged:  offset + w.n,
+			indexes:  damaged.Indexes,
+		}
+		return c.replyDamaged()
+	}
+
+	return c.finishSTOR(file, p, released, offset+w.n, err)
+}
+
+// Refuses a STOR before its transfer starts. In a pipeline the client sends
+// the file without waiting for the reply, so it is read and dropped to keep
+// the data connection in step.
+func (c *clientHandler) refuseSTOR(code int) error {
+	if c.pipeline && c.mode == ModeBlock && c.conn != nil {
+		config := c.blockConfig
+		config.OnRestart = nil
+		config.Repair = false
+		if err := config.Receive(io.Discard, c.conn); err != nil {
+			logger.Print(err)
+			c.closeDataConn()
+		}
+	}
+	return c.reply(code)
+}
+
+// Renames the uploaded file into place, or removes it after an error. After an
+// error the charged bytes are given back to the quota, and the bytes released
+// for the file replaced are charged again.
+func (c *clientHandler) finishSTOR(file *os.File, p string, released, charged int64, err error) error {
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), p)
 	}
 	if err != nil {
 		logger.Print(err)
+		c.closeDataConn()
+		c.server.chargeQuota(c.username, -charged)
+		c.server.restoreQuotaFile(c.username, p, released)
+		if !c.server.keepPartialUploads || errors.Is(err, ErrQuotaExceeded) {
+			os.Remove(file.Name())
+		}
+		if errors.Is(err, ErrQuotaExceeded) {
+			return c.reply(StatusExceededStorageAllocation)
+		}
 		return c.reply(StatusRequestedFileActionAborted)
 	}
 
+	c.server.storeQuotaFile(c.username, p, charged)
+
 	return c.reply(StatusFileActionCompleted)
 }
 
+// Partial uploads which are kept for a later resume have a fixed name,
+// otherwise every upload gets its own temporary file.
+func (c *clientHandler) createPartial(p string) (*os.File, error) {
+	dir, name := path.Split(p)
+	if c.server.keepPartialUploads {
+		return os.Create(partialPath(p))
+	}
+	file, err := os.CreateTemp(dir, "."+name+".*.part")
+	if err != nil {
+		return nil, err
+	}
+	return file, file.Chmod(0644)
+}
+
+func partialPath(p string) string {
+	dir, name := path.Split(p)
+	return path.Join(dir, "."+name+".part")
+}
+
 func (c *clientHandler) storeStreamMode(localFile io.Writer) error {
 	if _, err := io.Copy(localFile, c.conn); err != nil {
 		return err
@@ -107,5 +300,66 @@ func (c *clientHandler) storeStreamMode(localFile io.Writer) error {
 }
 
 func (c *clientHandler) storeBlockMode(localFile io.Writer) error {
-	return block.Receive(localFile, c.conn)
+	return c.blockConfig.Receive(localFile, c.conn)
+}
+
+func (c *clientHandler) storeCompressedMode(localFile io.Writer) error {
+	return compressed.Receive(localFile, c.conn, compressed.Filler(c.type_))
+}
+
+func (c *clientHandler) storeDeflateMode(localFile io.Writer) error {
+	r, err := zlib.NewReader(c.conn)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(localFile, r); err != nil {
+		return err
+	}
+	if err := r.Close(); err != nil {
+		return err
+	}
+
+	c.closeDataConn()
+
+	return nil
+}
+
+func (c *clientHandler) storeRecords(records record.Writer) error {
+	switch c.mode {
+	case ModeStream:
+		if err := record.Receive(records, c.conn); err != nil {
+			return err
+		}
+		c.closeDataConn()
+		return nil
+	case ModeBlock:
+		return c.blockConfig.ReceiveRecords(records, c.conn)
+	default:
+		return ErrModeNotSupported
+	}
+}
+
+// The size is the number of bytes a RETR would transfer in the current type.
+func (c *clientHandler) handleSIZE(param string) error {
+	file, err := os.Open(path.Join(c.rootDir, param))
+	if err != nil {
+		logger.Print(err)
+		return c.reply(StatusFileUnavailable)
+	}
+	defer file.Close()
+
+	fs, err := file.Stat()
+	if err != nil || fs.IsDir() {
+		return c.reply(StatusFileUnavailable)
+	}
+
+	size := fs.Size()
+	if c.type_ != TypeBinary {
+		if size, err = repr.Size(file, c.type_); err != nil {
+			logger.Print(err)
+			return c.reply(StatusRequestedFileActionAborted)
+		}
+	}
+
+	return c.reply(StatusFileStatus, size)
 }
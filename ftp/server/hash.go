@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+	"ftp/filehash"
+	"ftp/repr"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+var (
+	_ commandHandler = (*clientHandler).handleHASH
+	_ optsHandler    = (*clientHandler).handleOptsHASH
+	_ commandHandler = (*clientHandler).handleXCRC
+	_ commandHandler = (*clientHandler).handleXMD5
+	_ commandHandler = (*clientHandler).handleXSHA256
+)
+
+const DefaultHashAlgorithm = filehash.Default
+
+// The HASH feature line, the algorithm of the session is marked by "*".
+func (c *clientHandler) hashFeature() string {
+	names := make([]string, len(filehash.Algorithms))
+	for i, algorithm := range filehash.Algorithms {
+		names[i] = algorithm
+		if algorithm == c.hashAlgorithm {
+			names[i] += "*"
+		}
+	}
+	return "HASH " + strings.Join(names, ";")
+}
+
+// OPTS HASH [<algorithm>], selects the algorithm of HASH, or replies the
+// current one.
+func (c *clientHandler) handleOptsHASH(param string) error {
+	if param == "" {
+		return c.replyText(StatusOK, c.hashAlgorithm)
+	}
+
+	algorithm := strings.ToUpper(strings.TrimSpace(param))
+	if filehash.New(algorithm) == nil {
+		return c.reply(StatusCommandNotImplementedForParameter)
+	}
+	c.hashAlgorithm = algorithm
+	return c.replyText(StatusOK, algorithm)
+}
+
+// HASH<SP><pathname><CRLF>, as in draft-bryan-ftpext-hash. The reply is
+// the algorithm, the range of bytes hashed with both ends included, the
+// digest and the pathname:
+//
+//	213 SHA-256 0-1023 <hex digest> <pathname>
+//
+// A range set by RANG applies to HASH, but an offset set by REST does not.
+func (c *clientHandler) handleHASH(param string) error {
+	algorithm := c.hashAlgorithm
+	var start, end int64
+	if c.rangeEnd > 0 {
+		start, end = c.takeRestart()
+	}
+	return c.hashFile(param, algorithm, start, end, func(digest string, n int64) error {
+		last := start + n - 1
+		if n == 0 {
+			last = start
+		}
+		return c.replyText(StatusFileStatus, fmt.Sprintf("%s %d-%d %s %s", algorithm, start, last, digest, param))
+	})
+}
+
+// XCRC, XMD5 and XSHA256<SP><pathname><CRLF> are the commands of a single
+// algorithm before HASH, which reply the digest only.
+func (c *clientHandler) handleXCRC(param string) error {
+	return c.replyDigest(param, "CRC32")
+}
+
+func (c *clientHandler) handleXMD5(param string) error {
+	return c.replyDigest(param, "MD5")
+}
+
+func (c *clientHandler) handleXSHA256(param string) error {
+	return c.replyDigest(param, "SHA-256")
+}
+
+func (c *clientHandler) replyDigest(param, algorithm string) error {
+	return c.hashFile(param, algorithm, 0, 0, func(digest string, n int64) error {
+		return c.replyText(StatusFileActionCompleted, digest)
+	})
+}
+
+// Hashes the file as a RETR would transfer it in the current type, as SIZE
+// counts it, from start to end or to the end of file if end is 0. The digest
+// and the bytes hashed are replied by reply.
+func (c *clientHandler) hashFile(param, algorithm string, start, end int64, reply func(digest string, n int64) error) error {
+	if param == "" {
+		return c.reply(StatusSyntaxErrorInParametersOrArguments)
+	}
+
+	file, err := os.Open(path.Join(c.rootDir, param))
+	if err != nil {
+		logger.Print(err)
+		return c.reply(StatusFileUnavailable)
+	}
+	defer file.Close()
+
+	if fs, err := file.Stat(); err != nil || fs.IsDir() {
+		return c.reply(StatusFileUnavailable)
+	}
+
+	src := repr.NewReader(file, c.type_)
+	if start > 0 {
+		if err := c.skipRestart(file, src, start); err != nil {
+			logger.Print(err)
+			return c.reply(StatusInvalidRestart)
+		}
+	}
+	if end > 0 {
+		src = io.LimitReader(src, end-start)
+	}
+
+	h := filehash.New(algorithm)
+	n, err := io.Copy(h, src)
+	if err != nil {
+		logger.Print(err)
+		return c.reply(StatusRequestedFileActionAborted)
+	}
+	return reply(hex.EncodeToString(h.Sum(nil)), n)
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"ftp/rate"
+	"net"
+	"sync"
+)
+
+const (
+	DefaultMaxSessions      = 64
+	DefaultMaxSessionsPerIP = 8
+)
+
+// Counts the open sessions of a server, in total, by remote IP and by logged
+// in user. A limit of 0 means unlimited.
+type sessionCounter struct {
+	sync.Mutex
+	total  int
+	byIP   map[string]int
+	byUser map[string]int
+}
+
+func remoteIP(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+func (server *_ServerImpl) acquireSession(ip string) bool {
+	sessions := &server.sessions
+	sessions.Lock()
+	defer sessions.Unlock()
+
+	if server.maxSessions > 0 && sessions.total >= server.maxSessions {
+		return false
+	}
+	if server.maxSessionsPerIP > 0 && sessions.byIP[ip] >= server.maxSessionsPerIP {
+		return false
+	}
+
+	if sessions.byIP == nil {
+		sessions.byIP = make(map[string]int)
+	}
+	sessions.total++
+	sessions.byIP[ip]++
+	return true
+}
+
+func (server *_ServerImpl) releaseSession(ip string) {
+	sessions := &server.sessions
+	sessions.Lock()
+	defer sessions.Unlock()
+
+	sessions.total--
+	if sessions.byIP[ip]--; sessions.byIP[ip] <= 0 {
+		delete(sessions.byIP, ip)
+	}
+}
+
+func (server *_ServerImpl) acquireUser(username string) bool {
+	sessions := &server.sessions
+	sessions.Lock()
+	defer sessions.Unlock()
+
+	if server.maxSessionsPerUser > 0 && sessions.byUser[username] >= server.maxSessionsPerUser {
+		return false
+	}
+
+	if sessions.byUser == nil {
+		sessions.byUser = make(map[string]int)
+	}
+	sessions.byUser[username]++
+	return true
+}
+
+func (server *_ServerImpl) releaseUser(username string) {
+	sessions := &server.sessions
+	sessions.Lock()
+	defer sessions.Unlock()
+
+	if sessions.byUser[username]--; sessions.byUser[username] <= 0 {
+		delete(sessions.byUser, username)
+	}
+}
+
+// Bandwidth limiters shared by all sessions of the same user.
+type userLimiters struct {
+	sync.Mutex
+	rate     int64
+	limiters map[string]*rate.Limiter
+}
+
+func (server *_ServerImpl) userLimiter(username string) *rate.Limiter {
+	users := &server.userLimiters
+	users.Lock()
+	defer users.Unlock()
+
+	if users.rate <= 0 {
+		return nil
+	}
+	if users.limiters == nil {
+		users.limiters = make(map[string]*rate.Limiter)
+	}
+	limiter, has := users.limiters[username]
+	if !has {
+		limiter = rate.NewLimiter(users.rate)
+		users.limiters[username] = limiter
+	}
+	return limiter
+}
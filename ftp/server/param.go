@@ -1,7 +1,15 @@
 package server
 
+import (
+	"compress/zlib"
+	"ftp/block"
+	"strconv"
+	"strings"
+)
+
 var (
 	_ commandHandler = (*clientHandler).handleMODE
+	_ optsHandler    = (*clientHandler).handleOptsMODE
 	_ commandHandler = (*clientHandler).handleTYPE
 	_ commandHandler = (*clientHandler).handleSTRU
 )
@@ -10,17 +18,28 @@ const (
 	ModeStream     byte = 'S'
 	ModeBlock      byte = 'B'
 	ModeCompressed byte = 'C'
+	ModeDeflate    byte = 'Z'
 
 	TypeAscii  byte = 'A'
+	TypeEbcdic byte = 'E'
 	TypeBinary byte = 'I'
+	TypeLocal  byte = 'L'
 
-	StruFile byte = 'F'
+	FormNonPrint        byte = 'N'
+	FormTelnet          byte = 'T'
+	FormCarriageControl byte = 'C'
+
+	StruFile   byte = 'F'
+	StruRecord byte = 'R'
 )
 
 func (c *clientHandler) handleMODE(param string) error {
-	mode := param[0]
+	if len(param) != 1 {
+		return c.reply(StatusSyntaxErrorInParametersOrArguments)
+	}
+	mode := strings.ToUpper(param)[0]
 	switch mode {
-	case ModeStream, ModeBlock:
+	case ModeStream, ModeBlock, ModeCompressed, ModeDeflate:
 		c.mode = mode
 		return c.reply(StatusOK)
 	default:
@@ -28,24 +47,128 @@ func (c *clientHandler) handleMODE(param string) error {
 	}
 }
 
+// OPTS MODE <mode-code> <option> <value>, the options are
+//
+//	Z LEVEL <n>                     zlib compression level of MODE Z
+//	B FRAMING STANDARD|CHECKSUM     block framing of MODE B
+//	B REPAIR ON|OFF                 repair of damaged blocks by XBLK
+//	B HASH <algorithm>              checksum algorithm of the blocks sent
+//	B BLOCKSIZE <bytes>             size of the blocks sent
+//	B PIPELINE ON|OFF               files of STOR sent before the reply
+func (c *clientHandler) handleOptsMODE(param string) error {
+	fields := strings.Fields(strings.ToUpper(param))
+	if len(fields) != 3 {
+		return c.reply(StatusSyntaxErrorInParametersOrArguments)
+	}
+
+	switch fields[0] + " " + fields[1] {
+	case "Z LEVEL":
+		level, err := strconv.Atoi(fields[2])
+		if err != nil || level < zlib.HuffmanOnly || level > zlib.BestCompression {
+			return c.reply(StatusSyntaxErrorInParametersOrArguments)
+		}
+		c.deflateLevel = level
+	case "B FRAMING":
+		switch fields[2] {
+		case "STANDARD":
+			c.blockConfig.Checksum = false
+		case "CHECKSUM":
+			c.blockConfig.Checksum = true
+		default:
+			return c.reply(StatusSyntaxErrorInParametersOrArguments)
+		}
+	case "B HASH":
+		h, err := block.ParseHash(fields[2])
+		if err != nil {
+			return c.reply(StatusCommandNotImplementedForParameter)
+		}
+		c.blockConfig.Hash = h
+	case "B BLOCKSIZE":
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil || size <= 0 || size > block.MaxBlockSize {
+			return c.reply(StatusSyntaxErrorInParametersOrArguments)
+		}
+		c.blockConfig.BlockSize = size
+	case "B PIPELINE":
+		switch fields[2] {
+		case "ON":
+			c.pipeline = true
+		case "OFF":
+			c.pipeline = false
+		default:
+			return c.reply(StatusSyntaxErrorInParametersOrArguments)
+		}
+	case "B REPAIR":
+		switch fields[2] {
+		case "ON":
+			c.blockConfig.Repair = true
+		case "OFF":
+			c.blockConfig.Repair = false
+		default:
+			return c.reply(StatusSyntaxErrorInParametersOrArguments)
+		}
+	default:
+		return c.reply(StatusSyntaxErrorInParametersOrArguments)
+	}
+
+	return c.reply(StatusOK)
+}
+
+// The type-code is followed by a form-code for A and E, and by a byte size for L.
 func (c *clientHandler) handleTYPE(param string) error {
-	type_ := param[0]
+	fields := strings.Fields(strings.ToUpper(param))
+	if len(fields) == 0 || len(fields[0]) != 1 || len(fields) > 2 {
+		return c.reply(StatusSyntaxErrorInParametersOrArguments)
+	}
+
+	type_ := fields[0][0]
 	switch type_ {
-	case TypeAscii, TypeBinary:
-		c.type_ = type_
-		return c.reply(StatusOK)
+	case TypeAscii, TypeEbcdic:
+		// Format effectors are kept as they are, so Telnet format is the
+		// same as non-print. ASA carriage control is not supported.
+		if len(fields) == 2 {
+			switch fields[1] {
+			case string(FormNonPrint), string(FormTelnet):
+			case string(FormCarriageControl):
+				return c.reply(StatusCommandNotImplementedForParameter)
+			default:
+				return c.reply(StatusSyntaxErrorInParametersOrArguments)
+			}
+		}
+	case TypeBinary:
+		if len(fields) != 1 {
+			return c.reply(StatusSyntaxErrorInParametersOrArguments)
+		}
+	case TypeLocal:
+		// Only bytes of 8 bits, which is binary.
+		if len(fields) != 2 {
+			return c.reply(StatusSyntaxErrorInParametersOrArguments)
+		}
+		if fields[1] != "8" {
+			return c.reply(StatusCommandNotImplementedForParameter)
+		}
+		type_ = TypeBinary
 	default:
 		return c.reply(StatusCommandNotImplementedForParameter)
 	}
+
+	c.type_ = type_
+	return c.reply(StatusOK)
 }
 
 func (c *clientHandler) handleSTRU(param string) error {
-	stru := param[0]
+	if len(param) != 1 {
+		return c.reply(StatusSyntaxErrorInParametersOrArguments)
+	}
+	stru := strings.ToUpper(param)[0]
 	switch stru {
-	case StruFile:
+	case StruFile, StruRecord:
 		c.stru = stru
 		return c.reply(StatusOK)
 	default:
+		// Page structure is for the discontinuous files of systems like
+		// TOPS-20, which have no counterpart in a local file, so STRU P is
+		// not implemented.
 		return c.reply(StatusCommandNotImplementedForParameter)
 	}
 }
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+var (
+	ErrQuotaExceeded             = errors.New("quota exceeded")
+	_                siteHandler = (*clientHandler).handleSiteQUOTA
+)
+
+// Storage usage of one user, accounted for the uploads made through this
+// server. Each file is charged for what the user stored in it, so replacing a
+// file someone else stored gives nothing back. A limit of 0 means unlimited.
+type quota struct {
+	maxBytes int64
+	maxFiles int
+	bytes    int64
+	stored   map[string]int64 // the bytes charged for each file, by path
+}
+
+type quotas struct {
+	sync.Mutex
+	users map[string]*quota
+}
+
+func (server *_ServerImpl) SetUserQuota(username string, maxBytes int64, maxFiles int) {
+	quotas := &server.quotas
+	quotas.Lock()
+	defer quotas.Unlock()
+
+	if quotas.users == nil {
+		quotas.users = make(map[string]*quota)
+	}
+	q, has := quotas.users[username]
+	if !has {
+		q = &quota{stored: make(map[string]int64)}
+		quotas.users[username] = q
+	}
+	q.maxBytes, q.maxFiles = maxBytes, maxFiles
+}
+
+// Checks if the user may store the file at p, which replaces what the user
+// stored there before.
+func (server *_ServerImpl) checkQuota(username, p string) error {
+	quotas := &server.quotas
+	quotas.Lock()
+	defer quotas.Unlock()
+
+	q, has := quotas.users[username]
+	if !has {
+		return nil
+	}
+	old, stored := q.stored[p]
+	if q.maxBytes > 0 && q.bytes-old >= q.maxBytes {
+		return ErrQuotaExceeded
+	}
+	if !stored && q.maxFiles > 0 && len(q.stored) >= q.maxFiles {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// Adds n bytes to the user's usage, a negative n gives them back.
+func (server *_ServerImpl) chargeQuota(username string, n int64) error {
+	quotas := &server.quotas
+	quotas.Lock()
+	defer quotas.Unlock()
+
+	q, has := quotas.users[username]
+	if !has {
+		return nil
+	}
+	if n > 0 && q.maxBytes > 0 && q.bytes+n > q.maxBytes {
+		return ErrQuotaExceeded
+	}
+	if q.bytes += n; q.bytes < 0 {
+		q.bytes = 0
+	}
+	return nil
+}
+
+// Gives back the bytes charged for the file at p while an upload replaces it,
+// and returns them. The file keeps its place in the count of files.
+func (server *_ServerImpl) releaseQuotaFile(username, p string) int64 {
+	quotas := &server.quotas
+	quotas.Lock()
+	defer quotas.Unlock()
+
+	q, has := quotas.users[username]
+	if !has {
+		return 0
+	}
+	old, stored := q.stored[p]
+	if stored {
+		q.bytes -= old
+		q.stored[p] = 0
+	}
+	return old
+}
+
+// Records the file at p as stored by the user in n bytes, which are charged
+// already.
+func (server *_ServerImpl) storeQuotaFile(username, p string, n int64) {
+	quotas := &server.quotas
+	quotas.Lock()
+	defer quotas.Unlock()
+
+	if q, has := quotas.users[username]; has {
+		q.stored[p] = n
+	}
+}
+
+// Charges again the bytes released for the file at p, after the upload which
+// was to replace it failed.
+func (server *_ServerImpl) restoreQuotaFile(username, p string, released int64) {
+	quotas := &server.quotas
+	quotas.Lock()
+	defer quotas.Unlock()
+
+	if q, has := quotas.users[username]; has {
+		if _, stored := q.stored[p]; stored {
+			q.stored[p] = released
+			q.bytes += released
+		}
+	}
+}
+
+func (server *_ServerImpl) quotaUsage(username string) string {
+	quotas := &server.quotas
+	quotas.Lock()
+	defer quotas.Unlock()
+
+	limit := func(n int64) string {
+		if n <= 0 {
+			return "unlimited"
+		}
+		return fmt.Sprint(n)
+	}
+
+	q, has := quotas.users[username]
+	if !has {
+		return fmt.Sprintf("Quota for %s: unlimited", username)
+	}
+	return fmt.Sprintf("Quota for %s: %d of %s bytes, %d of %s files used",
+		username, q.bytes, limit(q.maxBytes), len(q.stored), limit(int64(q.maxFiles)))
+}
+
+// Charges every byte written to the user's quota, and fails the write once
+// the quota is exceeded. The written bytes are counted in n.
+type quotaWriter struct {
+	w        io.Writer
+	server   *_ServerImpl
+	username string
+	n        int64
+}
+
+func (w *quotaWriter) Write(p []byte) (int, error) {
+	if err := w.server.chargeQuota(w.username, int64(len(p))); err != nil {
+		return 0, err
+	}
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	if n < len(p) {
+		w.server.chargeQuota(w.username, int64(n-len(p)))
+	}
+	return n, err
+}
+
+func (c *clientHandler) handleSiteQUOTA(param string) error {
+	if !c.login {
+		return c.reply(StatusNotLoggedIn)
+	}
+	return c.reply(StatusSystemStatus, c.server.quotaUsage(c.username))
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"errors"
+	"ftp/block"
+	"os"
+	"strconv"
+)
+
+var (
+	_ commandHandler = (*clientHandler).handleXBLK
+)
+
+// An upload in block mode with damaged blocks, which waits for the blocks to
+// be resent by XBLK.
+type uploadRepair struct {
+	file     *os.File
+	path     string
+	released int64 // bytes of the file replaced, given back to the quota
+	charged  int64 // bytes charged to the quota
+	indexes  []int64
+}
+
+// XBLK<SP><index><CRLF> resends the damaged block of index, in block mode of
+// the checksummed framing with repair on. After a STOR is replied by the
+// damaged blocks the client resends them, otherwise the server resends the
+// block of the last RETR.
+func (c *clientHandler) handleXBLK(param string) error {
+	index, err := strconv.ParseInt(param, 10, 64)
+	if err != nil || index < 0 {
+		return c.reply(StatusSyntaxErrorInParametersOrArguments)
+	}
+	if c.mode != ModeBlock || !c.blockConfig.Checksum || c.type_ != TypeBinary {
+		return c.reply(StatusCommandNotImplementedForParameter)
+	}
+	if c.conn == nil {
+		return c.reply(StatusCannotOpenDataConn)
+	}
+
+	switch {
+	case c.repair != nil:
+		return c.repairUpload(index)
+	case c.lastRETR != "":
+		return c.resendRETR(index)
+	default:
+		return c.reply(StatusBadSequence)
+	}
+}
+
+func (c *clientHandler) repairUpload(index int64) error {
+	repair := c.repair
+	i := 0
+	for i < len(repair.indexes) && repair.indexes[i] != index {
+		i++
+	}
+	if i == len(repair.indexes) {
+		return c.reply(StatusSyntaxErrorInParametersOrArguments)
+	}
+
+	c.reply(StatusTransferStarted)
+
+	// A block damaged again stays to be resent.
+	err := c.blockConfig.ReceiveResent(repair.file, index, c.conn)
+	if err != nil && !errors.Is(err, block.ErrBrokenBlock) {
+		c.repair = nil
+		return c.finishSTOR(repair.file, repair.path, repair.released, repair.charged, err)
+	}
+	if err == nil {
+		repair.indexes = append(repair.indexes[:i], repair.indexes[i+1:]...)
+	}
+
+	if len(repair.indexes) > 0 {
+		return c.replyDamaged()
+	}
+	c.repair = nil
+	return c.finishSTOR(repair.file, repair.path, repair.released, repair.charged, nil)
+}
+
+func (c *clientHandler) resendRETR(index int64) error {
+	file, err := os.Open(c.lastRETR)
+	if err != nil {
+		logger.Print(err)
+		return c.reply(StatusFileUnavailable)
+	}
+	defer file.Close()
+
+	c.reply(StatusTransferStarted)
+
+	if err := c.blockConfig.Resend(c.conn, file, index); err != nil {
+		logger.Print(err)
+		c.closeDataConn()
+		return c.reply(StatusRequestedFileActionAborted)
+	}
+
+	return c.reply(StatusFileActionCompleted)
+}
+
+// Replies the damaged blocks of the upload, one index a line.
+func (c *clientHandler) replyDamaged() error {
+	lines := make([]string, len(c.repair.indexes))
+	for i, index := range c.repair.indexes {
+		lines[i] = strconv.FormatInt(index, 10)
+	}
+	return c.replyMultiline(StatusFileActionPending, "Damaged blocks, resend them by XBLK:", lines)
+}
+
+// Gives up the upload waiting for repair, as another transfer starts or the
+// session ends.
+func (c *clientHandler) abortRepair() {
+	if repair := c.repair; repair != nil {
+		c.repair = nil
+		repair.file.Close()
+		c.server.chargeQuota(c.username, -repair.charged)
+		c.server.restoreQuotaFile(c.username, repair.path, repair.released)
+		if !c.server.keepPartialUploads {
+			os.Remove(repair.file.Name())
+		}
+	}
+}
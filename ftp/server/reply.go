@@ -3,49 +3,75 @@ package server
 import "fmt"
 
 const (
+	StatusRestartMarker   = 110
 	StatusTransferStarted = 125
 	StatusFileStatusOK    = 150
 
 	StatusOK                  = 200
+	StatusSystemStatus        = 211
+	StatusFileStatus          = 213
 	StatusReady               = 220
 	StatusCloseConn           = 221
 	StatusEnteringPasv        = 227
+	StatusEnteringEpsv        = 229
 	StatuLoginProceed         = 230
 	StatusFileActionCompleted = 250
 
 	StatusUsernameOKNeedPassword = 331
 	StatusNeedAccountForLogin    = 332
+	StatusFileActionPending      = 350
+
+	StatusServiceNotAvailable = 421
+	StatusCannotOpenDataConn  = 425
 
 	StatusSyntaxError                        = 500
 	StatusSyntaxErrorInParametersOrArguments = 501
+	StatusBadSequence                        = 503
 	StatusCommandNotImplementedForParameter  = 504
 	StatusNotLoggedIn                        = 530
 	StatusFileUnavailable                    = 550
 	StatusRequestedFileActionAborted         = 551
+	StatusExceededStorageAllocation          = 552
+	StatusInvalidRestart                     = 554
+
+	StatusNetworkProtocolNotSupported = 522
 )
 
 var ErrUnknownCode = fmt.Errorf("unknown code")
 
 var codeMessages = map[int]string{
+	StatusRestartMarker:   "MARK %s = %s",
 	StatusTransferStarted: "Data connection already open; transfer starting.",
 	StatusFileStatusOK:    "File status okay; about to open data connection.",
 
 	StatusOK:                  "Command okay.",
+	StatusSystemStatus:        "%s.",
+	StatusFileStatus:          "%d",
 	StatusReady:               "Service ready for new user.",
 	StatusCloseConn:           "Service closing control connection.",
 	StatusEnteringPasv:        "Entering Passive Mode (%s).",
+	StatusEnteringEpsv:        "Entering Extended Passive Mode (|||%s|).",
 	StatuLoginProceed:         "User logged in, proceed.",
 	StatusFileActionCompleted: "Requested file action okay, completed.",
 
 	StatusUsernameOKNeedPassword: "User name okay, need password.",
 	StatusNeedAccountForLogin:    "Need account for login.",
+	StatusFileActionPending:      "Requested file action pending further information.",
+
+	StatusServiceNotAvailable: "Service not available, %s.",
+	StatusCannotOpenDataConn:  "Can't open data connection.",
 
 	StatusSyntaxError:                        "Syntax error, command unrecognized.",
 	StatusSyntaxErrorInParametersOrArguments: "Syntax error in parameters or arguments.",
+	StatusBadSequence:                        "Bad sequence of commands.",
 	StatusCommandNotImplementedForParameter:  "Command not implemented for that parameter.",
 	StatusNotLoggedIn:                        "Not logged in.",
 	StatusFileUnavailable:                    "File unavailable.",
 	StatusRequestedFileActionAborted:         "Requested file action aborted, file unavailable.",
+	StatusExceededStorageAllocation:          "Requested file action aborted, exceeded storage allocation.",
+	StatusInvalidRestart:                     "Requested action not taken, invalid REST parameter.",
+
+	StatusNetworkProtocolNotSupported: "Network protocol not supported, use (1,2).",
 }
 
 func (c *clientHandler) reply(code int, args ...interface{}) error {
@@ -56,3 +82,31 @@ func (c *clientHandler) reply(code int, args ...interface{}) error {
 	}
 	return ErrUnknownCode
 }
+
+// Replies with text instead of the message of the code.
+func (c *clientHandler) replyText(code int, text string) error {
+	if _, has := codeMessages[code]; !has {
+		return ErrUnknownCode
+	}
+
+	resp := fmt.Sprintf("%d %s", code, text)
+	logger.Printf("reply %s %s", c.username, resp)
+	return c.ctrl.PrintfLine("%s", resp)
+}
+
+// Replies with a multi-line reply. The message of the code is formatted with
+// args as the last line.
+func (c *clientHandler) replyMultiline(code int, first string, lines []string, args ...interface{}) error {
+	msg, has := codeMessages[code]
+	if !has {
+		return ErrUnknownCode
+	}
+
+	resp := fmt.Sprintf("%d-%s\r\n", code, first)
+	for _, line := range lines {
+		resp += " " + line + "\r\n"
+	}
+	resp += fmt.Sprintf("%d %s", code, fmt.Sprintf(msg, args...))
+	logger.Printf("reply %s %s", c.username, resp)
+	return c.ctrl.PrintfLine("%s", resp)
+}
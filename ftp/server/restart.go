@@ -0,0 +1,111 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	_ commandHandler = (*clientHandler).handleREST
+	_ commandHandler = (*clientHandler).handleRANG
+)
+
+// REST<SP><marker><CRLF>, the marker is the byte offset to restart the next
+// RETR or STOR at, counted in the current type as the restart markers of
+// block mode.
+func (c *clientHandler) handleREST(param string) error {
+	offset, err := strconv.ParseInt(param, 10, 64)
+	if err != nil || offset < 0 {
+		return c.reply(StatusSyntaxErrorInParametersOrArguments)
+	}
+
+	c.restart = offset
+	c.rangeEnd = 0
+	return c.reply(StatusFileActionPending)
+}
+
+// RANG<SP><start><SP><end><CRLF>, as in draft-bryan-ftp-range, restricts the
+// next RETR or HASH to the bytes from start to end, both included and
+// counted as by REST. "RANG 1 0" resets the range.
+func (c *clientHandler) handleRANG(param string) error {
+	fields := strings.Fields(param)
+	if len(fields) != 2 {
+		return c.reply(StatusSyntaxErrorInParametersOrArguments)
+	}
+	start, err1 := strconv.ParseInt(fields[0], 10, 64)
+	end, err2 := strconv.ParseInt(fields[1], 10, 64)
+	if err1 != nil || err2 != nil || start < 0 {
+		return c.reply(StatusSyntaxErrorInParametersOrArguments)
+	}
+
+	if start == 1 && end == 0 {
+		c.restart, c.rangeEnd = 0, 0
+		return c.replyText(StatusFileActionPending, "Byte range reset.")
+	}
+	if end < start {
+		return c.reply(StatusSyntaxErrorInParametersOrArguments)
+	}
+
+	c.restart, c.rangeEnd = start, end+1
+	return c.replyText(StatusFileActionPending, fmt.Sprintf("Restarting at %d. Ending byte range at %d.", start, end))
+}
+
+// Returns the offset set by REST or RANG and the end of the range after it,
+// 0 if there is none, which apply to one transfer only. The restart markers
+// of block mode continue from the offset.
+func (c *clientHandler) takeRestart() (int64, int64) {
+	offset, end := c.restart, c.rangeEnd
+	c.restart, c.rangeEnd = 0, 0
+	c.blockConfig.RestartOffset = offset
+	return offset, end
+}
+
+// Skips the first offset bytes of src, the file converted to the current type.
+func (c *clientHandler) skipRestart(file *os.File, src io.Reader, offset int64) error {
+	if c.type_ == TypeBinary {
+		fs, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		if offset > fs.Size() {
+			return io.ErrUnexpectedEOF
+		}
+		_, err = file.Seek(offset, io.SeekStart)
+		return err
+	}
+
+	_, err := io.CopyN(io.Discard, src, offset)
+	return err
+}
+
+// Reopens the partial file of a failed upload to append from offset. Only
+// binary uploads can be resumed, in other types the offset in the file is
+// not known.
+func (c *clientHandler) resumePartial(p string, offset int64) (*os.File, error) {
+	if c.type_ != TypeBinary || !c.server.keepPartialUploads {
+		return nil, os.ErrInvalid
+	}
+
+	file, err := os.OpenFile(partialPath(p), os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	fs, err := file.Stat()
+	if err == nil && offset > fs.Size() {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil {
+		err = file.Truncate(offset)
+	}
+	if err == nil {
+		_, err = file.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
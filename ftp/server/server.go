@@ -1,17 +1,76 @@
 package server
 
 import (
+	"ftp/rate"
 	"net"
+	"time"
 )
 
 type FtpServer interface {
 	Listen(port int) error
 	Close() error
 	SetRootDir(string)
+
+	// Timeouts are given in seconds, 0 disables the timeout.
+	SetIdleTimeout(seconds int)
+	SetDataTimeout(seconds int)
+	SetLoginTimeout(seconds int)
+
+	// Limits on concurrent sessions, 0 means unlimited.
+	SetMaxSessions(n int)
+	SetMaxSessionsPerIP(n int)
+	SetMaxSessionsPerUser(n int)
+
+	// Brute-force protection. After n failed logins of an IP, over all its
+	// sessions, the session is closed and the IP is banned for the ban
+	// duration, 0 disables either. Its sessions already open are closed at
+	// their next PASS. Failures are forgotten after as long, or at a login.
+	SetMaxLoginAttempts(n int)
+	SetLoginFailureDelay(milliseconds int)
+	SetBanDuration(seconds int)
+
+	// Bandwidth limits of data connections in bytes per second, 0 means
+	// unlimited. The global limit is shared by all sessions, the user limit
+	// by all sessions of one user.
+	SetRateLimit(bytesPerSecond int64)
+	SetUserRateLimit(bytesPerSecond int64)
+	SetSessionRateLimit(bytesPerSecond int64)
+
+	// Storage quota of a user's uploads, 0 means unlimited.
+	SetUserQuota(username string, maxBytes int64, maxFiles int)
+
+	// Keep the partial file of a failed upload instead of removing it, so
+	// that it can be resumed by REST and STOR.
+	SetKeepPartialUploads(bool)
+	// Bytes between two restart markers sent in block mode, 0 sends none.
+	SetRestartInterval(bytes int64)
 }
 
+const (
+	DefaultIdleTimeout  = 5 * time.Minute
+	DefaultDataTimeout  = time.Minute
+	DefaultLoginTimeout = time.Minute
+
+	DefaultRestartInterval = 1 << 20
+)
+
 func NewFtpServer() FtpServer {
-	return &_ServerImpl{}
+	return &_ServerImpl{
+		idleTimeout:  DefaultIdleTimeout,
+		dataTimeout:  DefaultDataTimeout,
+		loginTimeout: DefaultLoginTimeout,
+
+		maxSessions:      DefaultMaxSessions,
+		maxSessionsPerIP: DefaultMaxSessionsPerIP,
+
+		maxLoginAttempts:  DefaultMaxLoginAttempts,
+		loginFailureDelay: DefaultLoginFailureDelay,
+		banDuration:       DefaultBanDuration,
+
+		limiter: rate.NewLimiter(0),
+
+		restartInterval: DefaultRestartInterval,
+	}
 }
 
 var _ FtpServer = (*_ServerImpl)(nil)
@@ -21,6 +80,29 @@ type _ServerImpl struct {
 	listener *net.TCPListener
 	rootDir  string
 	// handlers map[chan<- bool]struct{} // notify all handlers to stop
+
+	idleTimeout  time.Duration // control connection without any command
+	dataTimeout  time.Duration // data connection without any traffic
+	loginTimeout time.Duration // control connection without a successful login
+
+	sessions           sessionCounter
+	maxSessions        int
+	maxSessionsPerIP   int
+	maxSessionsPerUser int
+
+	bans              banList
+	maxLoginAttempts  int
+	loginFailureDelay time.Duration // grows with each failure in a session
+	banDuration       time.Duration
+
+	limiter      *rate.Limiter
+	userLimiters userLimiters
+	sessionRate  int64
+
+	quotas quotas
+
+	keepPartialUploads bool
+	restartInterval    int64
 }
 
 func (server *_ServerImpl) Listen(port int) error {
@@ -45,7 +127,7 @@ func (server *_ServerImpl) Listen(port int) error {
 					logger.Printf("accepted connection from %s", conn.RemoteAddr())
 					// channel := make(chan bool)
 					// server.handlers[channel] = struct{}{}
-					go handleClient(conn, server.rootDir) //Todo: pass channel to goroutine
+					go handleClient(conn, server) //Todo: pass channel to goroutine
 				}
 			}
 		}()
@@ -72,3 +154,66 @@ func (server *_ServerImpl) Close() error {
 func (server *_ServerImpl) SetRootDir(dir string) {
 	server.rootDir = dir
 }
+
+func (server *_ServerImpl) SetIdleTimeout(seconds int) {
+	server.idleTimeout = time.Duration(seconds) * time.Second
+}
+
+func (server *_ServerImpl) SetDataTimeout(seconds int) {
+	server.dataTimeout = time.Duration(seconds) * time.Second
+}
+
+func (server *_ServerImpl) SetLoginTimeout(seconds int) {
+	server.loginTimeout = time.Duration(seconds) * time.Second
+}
+
+func (server *_ServerImpl) SetMaxSessions(n int) {
+	server.maxSessions = n
+}
+
+func (server *_ServerImpl) SetMaxSessionsPerIP(n int) {
+	server.maxSessionsPerIP = n
+}
+
+func (server *_ServerImpl) SetMaxSessionsPerUser(n int) {
+	server.maxSessionsPerUser = n
+}
+
+func (server *_ServerImpl) SetMaxLoginAttempts(n int) {
+	server.maxLoginAttempts = n
+}
+
+func (server *_ServerImpl) SetLoginFailureDelay(milliseconds int) {
+	server.loginFailureDelay = time.Duration(milliseconds) * time.Millisecond
+}
+
+func (server *_ServerImpl) SetBanDuration(seconds int) {
+	server.banDuration = time.Duration(seconds) * time.Second
+}
+
+func (server *_ServerImpl) SetRateLimit(bytesPerSecond int64) {
+	server.limiter.SetRate(bytesPerSecond)
+}
+
+func (server *_ServerImpl) SetUserRateLimit(bytesPerSecond int64) {
+	users := &server.userLimiters
+	users.Lock()
+	defer users.Unlock()
+
+	users.rate = bytesPerSecond
+	for _, limiter := range users.limiters {
+		limiter.SetRate(bytesPerSecond)
+	}
+}
+
+func (server *_ServerImpl) SetSessionRateLimit(bytesPerSecond int64) {
+	server.sessionRate = bytesPerSecond
+}
+
+func (server *_ServerImpl) SetKeepPartialUploads(keep bool) {
+	server.keepPartialUploads = keep
+}
+
+func (server *_ServerImpl) SetRestartInterval(bytes int64) {
+	server.restartInterval = bytes
+}
@@ -2,14 +2,21 @@ package server
 
 import (
 	"bytes"
+	"compress/zlib"
 	"crypto/md5"
+	"crypto/sha256"
 	"fmt"
+	"ftp/block"
 	"ftp/cmd"
+	"ftp/compressed"
+	"hash/crc32"
 	"io"
+	"math/rand"
 	"net"
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 var (
@@ -51,9 +58,15 @@ func assertReply(t *testing.T, c net.Conn, expect, msg string) {
 
 // Setup a mock connection, test if service ready, and return the client conn.
 func setupConn(t *testing.T) net.Conn {
+	t.Helper()
+	return setupServerConn(t, NewFtpServer().(*_ServerImpl))
+}
+
+// Same as setupConn, but the session is served with the given server's configuration.
+func setupServerConn(t *testing.T, server *_ServerImpl) net.Conn {
 	t.Helper()
 	c, s := net.Pipe()
-	go handleClient(s, "")
+	go handleClient(s, server)
 
 	// After connection establishment, expects 220
 	assertReply(t, c, "220 Service ready for new user.\r\n", "Service not ready")
@@ -148,6 +161,32 @@ func Test_Port(t *testing.T) {
 	})
 }
 
+func Test_Epsv(t *testing.T) {
+	c := setupConn(t)
+	defer teardownConn(t, c)
+
+	c.Write([]byte("EPSV 3\r\n"))
+	assertReply(t, c, "522 Network protocol not supported, use (1,2).\r\n", "test epsv error")
+	c.Write([]byte("EPSV ALL\r\n"))
+	assertReply(t, c, "200 Command okay.\r\n", "test epsv error")
+	c.Write([]byte("PASV\r\n"))
+	assertReply(t, c, "503 Bad sequence of commands.\r\n", "test epsv all error")
+	c.Write([]byte("PORT 127,0,0,1,21,56\r\n"))
+	assertReply(t, c, "503 Bad sequence of commands.\r\n", "test epsv all error")
+
+	c.Write([]byte("EPSV 1\r\n"))
+	readReply(c)
+	var port int
+	if _, err := fmt.Sscanf(string(__buffer[:__n]), "229 Entering Extended Passive Mode (|||%d|).\r\n", &port); err != nil {
+		t.Fatal("test epsv error", string(__buffer[:__n]))
+	}
+	dataConn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataConn.Close()
+}
+
 func Test_Mode(t *testing.T) {
 	t.Run("", func(t *testing.T) {
 		c := setupConn(t)
@@ -160,6 +199,12 @@ func Test_Mode(t *testing.T) {
 		assertReply(t, c, "200 Command okay.\r\n", "test mode error")
 
 		c.Write([]byte(fmt.Sprintf(cmd.MODE, 'C')))
+		assertReply(t, c, "200 Command okay.\r\n", "test mode error")
+
+		c.Write([]byte(fmt.Sprintf(cmd.MODE, 'Z')))
+		assertReply(t, c, "200 Command okay.\r\n", "test mode error")
+
+		c.Write([]byte(fmt.Sprintf(cmd.MODE, 'X')))
 		assertReply(t, c, "504 Command not implemented for that parameter.\r\n", "test mode error")
 	})
 }
@@ -174,6 +219,9 @@ func Test_Stor(t *testing.T) {
 		c.Write([]byte(fmt.Sprintf(cmd.PASS, "test")))
 		assertReply(t, c, "230 User logged in, proceed.\r\n", "test valid account error")
 
+		c.Write([]byte(fmt.Sprintf(cmd.TYPE, "I")))
+		assertReply(t, c, "200 Command okay.\r\n", "test type error")
+
 		c.Write([]byte(fmt.Sprintf(cmd.STOR, "test.txt")))
 		assertReply(t, c, "150 File status okay; about to open data connection.\r\n", "")
 
@@ -225,6 +273,9 @@ func Test_Retr(t *testing.T) {
 		c := setupConn(t)
 		defer teardownConn(t, c)
 
+		c.Write([]byte(fmt.Sprintf(cmd.TYPE, "I")))
+		assertReply(t, c, "200 Command okay.\r\n", "test type error")
+
 		c.Write([]byte(fmt.Sprintf(cmd.RETR, "test_root/small.txt")))
 		assertReply(t, c, "150 File status okay; about to open data connection.\r\n", "")
 
@@ -267,3 +318,993 @@ func Test_Retr(t *testing.T) {
 		}
 	})
 }
+
+func Test_Timeout(t *testing.T) {
+	t.Run("idle", func(t *testing.T) {
+		server := NewFtpServer().(*_ServerImpl)
+		server.idleTimeout = 100 * time.Millisecond
+		c := setupServerConn(t, server)
+		defer c.Close()
+
+		c.Write([]byte(fmt.Sprintf(cmd.USER, "test")))
+		assertReply(t, c, "331 User name okay, need password.\r\n", "test valid user name error")
+		c.Write([]byte(fmt.Sprintf(cmd.PASS, "test")))
+		assertReply(t, c, "230 User logged in, proceed.\r\n", "test valid account error")
+
+		assertReply(t, c, "421 Service not available, idle timeout.\r\n", "test idle timeout error")
+	})
+
+	t.Run("noop", func(t *testing.T) {
+		server := NewFtpServer().(*_ServerImpl)
+		server.idleTimeout = 200 * time.Millisecond
+		c := setupServerConn(t, server)
+		defer c.Close()
+
+		// NOOP keeps the session alive past the idle timeout.
+		for i := 0; i < 3; i++ {
+			time.Sleep(100 * time.Millisecond)
+			c.Write([]byte(cmd.NOOP))
+			assertReply(t, c, "200 Command okay.\r\n", "test noop error")
+		}
+		assertReply(t, c, "421 Service not available, idle timeout.\r\n", "test idle timeout error")
+	})
+
+	t.Run("login", func(t *testing.T) {
+		server := NewFtpServer().(*_ServerImpl)
+		server.loginTimeout = 100 * time.Millisecond
+		c := setupServerConn(t, server)
+		defer c.Close()
+
+		c.Write([]byte(fmt.Sprintf(cmd.USER, "test")))
+		assertReply(t, c, "331 User name okay, need password.\r\n", "test valid user name error")
+
+		assertReply(t, c, "421 Service not available, login timeout.\r\n", "test login timeout error")
+	})
+
+	t.Run("data", func(t *testing.T) {
+		server := NewFtpServer().(*_ServerImpl)
+		server.dataTimeout = 100 * time.Millisecond
+		c := setupServerConn(t, server)
+		defer teardownConn(t, c)
+		login(t, c)
+
+		dataConn, err := net.Listen("tcp", ":5456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dataConn.Close()
+
+		accept := make(chan net.Conn)
+		go func() {
+			conn, err := dataConn.Accept()
+			if err != nil {
+				t.Log(err)
+			}
+			accept <- conn
+		}()
+
+		c.Write([]byte(fmt.Sprintf(cmd.PORT, 127, 0, 0, 1, 21, 80)))
+		assertReply(t, c, "200 Command okay.\r\n", "test port error")
+		dataChan := <-accept
+		defer dataChan.Close()
+		defer os.Remove("stalled.txt")
+
+		// Send nothing and keep the data connection open.
+		c.Write([]byte(fmt.Sprintf(cmd.STOR, "stalled.txt")))
+		assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+		assertReply(t, c, "551 Requested file action aborted, file unavailable.\r\n", "test data timeout error")
+	})
+}
+
+func Test_SessionLimit(t *testing.T) {
+	t.Run("per ip", func(t *testing.T) {
+		server := NewFtpServer().(*_ServerImpl)
+		server.maxSessionsPerIP = 1
+		c := setupServerConn(t, server)
+		defer teardownConn(t, c)
+
+		// net.Pipe gives every connection the same remote address.
+		c2, s2 := net.Pipe()
+		defer c2.Close()
+		go handleClient(s2, server)
+		assertReply(t, c2, "421 Service not available, too many connections.\r\n", "test session limit error")
+	})
+
+	t.Run("per user", func(t *testing.T) {
+		server := NewFtpServer().(*_ServerImpl)
+		server.maxSessionsPerUser = 1
+		c := setupServerConn(t, server)
+		defer teardownConn(t, c)
+
+		c.Write([]byte(fmt.Sprintf(cmd.USER, "test")))
+		assertReply(t, c, "331 User name okay, need password.\r\n", "test valid user name error")
+		c.Write([]byte(fmt.Sprintf(cmd.PASS, "test")))
+		assertReply(t, c, "230 User logged in, proceed.\r\n", "test valid account error")
+
+		c2 := setupServerConn(t, server)
+		defer c2.Close()
+		c2.Write([]byte(fmt.Sprintf(cmd.USER, "test")))
+		assertReply(t, c2, "331 User name okay, need password.\r\n", "test valid user name error")
+		c2.Write([]byte(fmt.Sprintf(cmd.PASS, "test")))
+		assertReply(t, c2, "421 Service not available, too many connections.\r\n", "test user session limit error")
+	})
+}
+
+func Test_LoginAttempts(t *testing.T) {
+	server := NewFtpServer().(*_ServerImpl)
+	server.maxLoginAttempts = 2
+	server.loginFailureDelay = time.Millisecond
+	c := setupServerConn(t, server)
+	defer func() { c.Close() }()
+
+	// A login forgets the failures before it.
+	c.Write([]byte(fmt.Sprintf(cmd.USER, "test")))
+	assertReply(t, c, "331 User name okay, need password.\r\n", "test valid user name error")
+	c.Write([]byte(fmt.Sprintf(cmd.PASS, "wrong")))
+	assertReply(t, c, "530 Not logged in.\r\n", "test invalid account error")
+	login(t, c)
+	if server.bans.failures[remoteIP(c)] != nil {
+		t.Fatal("failures should be cleared by a login")
+	}
+
+	c.Write([]byte(fmt.Sprintf(cmd.USER, "test")))
+	assertReply(t, c, "230 User logged in, proceed.\r\n", "test valid user name error")
+	c.Write([]byte(fmt.Sprintf(cmd.PASS, "wrong")))
+	assertReply(t, c, "530 Not logged in.\r\n", "test invalid account error")
+
+	// A session opened before the ban.
+	open := setupServerConn(t, server)
+	defer open.Close()
+
+	// The failures are counted by IP, reconnecting does not reset them.
+	teardownConn(t, c)
+	c = setupServerConn(t, server)
+	c.Write([]byte(fmt.Sprintf(cmd.USER, "test")))
+	assertReply(t, c, "331 User name okay, need password.\r\n", "test valid user name error")
+	c.Write([]byte(fmt.Sprintf(cmd.PASS, "wrong")))
+	assertReply(t, c, "421 Service not available, too many failed logins.\r\n", "test login attempts error")
+
+	// The address is banned now.
+	c2, s2 := net.Pipe()
+	defer c2.Close()
+	go handleClient(s2, server)
+	assertReply(t, c2, "421 Service not available, too many failed logins.\r\n", "test ban error")
+
+	// Sessions already open are refused as well, with the right password.
+	open.Write([]byte(fmt.Sprintf(cmd.USER, "test")))
+	assertReply(t, open, "331 User name okay, need password.\r\n", "test valid user name error")
+	open.Write([]byte(fmt.Sprintf(cmd.PASS, "test")))
+	assertReply(t, open, "421 Service not available, too many failed logins.\r\n", "test ban error")
+
+	server.bans.until[remoteIP(s2)] = time.Now()
+	c3 := setupServerConn(t, server)
+	teardownConn(t, c3)
+}
+
+func Test_UserRateLimit(t *testing.T) {
+	server := NewFtpServer().(*_ServerImpl)
+	if server.userLimiter("test") != nil {
+		t.Error("user limiter should be nil without a user rate limit")
+	}
+
+	server.SetUserRateLimit(1 << 20)
+	if limiter := server.userLimiter("test"); limiter == nil || limiter != server.userLimiter("test") {
+		t.Error("sessions of the same user should share a limiter")
+	}
+	if server.userLimiter("test") == server.userLimiter("pikachu") {
+		t.Error("different users should not share a limiter")
+	}
+
+	server.SetUserRateLimit(1 << 10)
+	if rate := server.userLimiter("test").Rate(); rate != 1<<10 {
+		t.Errorf("user rate limit not updated: %d", rate)
+	}
+}
+
+func login(t *testing.T, c net.Conn) {
+	t.Helper()
+	c.Write([]byte(fmt.Sprintf(cmd.USER, "test")))
+	assertReply(t, c, "331 User name okay, need password.\r\n", "test valid user name error")
+	c.Write([]byte(fmt.Sprintf(cmd.PASS, "test")))
+	assertReply(t, c, "230 User logged in, proceed.\r\n", "test valid account error")
+}
+
+// Sends a PORT command and returns the data connection opened by the server.
+func setupDataConn(t *testing.T, c net.Conn) net.Conn {
+	t.Helper()
+	listener, err := net.Listen("tcp", ":5456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	accept := make(chan net.Conn)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Log(err)
+		}
+		accept <- conn
+	}()
+
+	c.Write([]byte(fmt.Sprintf(cmd.PORT, 127, 0, 0, 1, 21, 80)))
+	assertReply(t, c, "200 Command okay.\r\n", "test port error")
+	return <-accept
+}
+
+func Test_Quota(t *testing.T) {
+	server := NewFtpServer().(*_ServerImpl)
+	server.SetUserQuota("test", 16, 2)
+	c := setupServerConn(t, server)
+	defer teardownConn(t, c)
+
+	// Only the user logged in is charged, USER alone is not enough.
+	c.Write([]byte(fmt.Sprintf(cmd.STOR, "quota.txt")))
+	assertReply(t, c, "530 Not logged in.\r\n", "test store without login error")
+	c.Write([]byte(fmt.Sprintf(cmd.USER, "test")))
+	assertReply(t, c, "331 User name okay, need password.\r\n", "test valid user name error")
+	c.Write([]byte(fmt.Sprintf(cmd.STOR, "quota.txt")))
+	assertReply(t, c, "530 Not logged in.\r\n", "test store without login error")
+	c.Write([]byte(fmt.Sprintf(cmd.PASS, "test")))
+	assertReply(t, c, "230 User logged in, proceed.\r\n", "test valid account error")
+	defer os.Remove("quota.txt")
+	defer os.Remove("quota2.txt")
+
+	stor := func(name, data, expect, msg string) {
+		t.Helper()
+		dataConn := setupDataConn(t, c)
+		defer dataConn.Close()
+		c.Write([]byte(fmt.Sprintf(cmd.STOR, name)))
+		assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+		dataConn.Write([]byte(data))
+		dataConn.Close()
+		assertReply(t, c, expect, msg)
+	}
+
+	stor("quota.txt", "test data\r\n", "250 Requested file action okay, completed.\r\n", "test store error")
+	stor("quota2.txt", "test data test data\r\n", "552 Requested file action aborted, exceeded storage allocation.\r\n", "test byte quota error")
+	if _, err := os.Stat("quota2.txt"); err == nil {
+		t.Error("aborted upload should be removed")
+	}
+
+	c.Write([]byte("SITE QUOTA\r\n"))
+	assertReply(t, c, "211 Quota for test: 10 of 16 bytes, 1 of 2 files used.\r\n", "test quota usage error")
+
+	stor("quota2.txt", "ok", "250 Requested file action okay, completed.\r\n", "test store error")
+
+	// No more files
+	c.Write([]byte(fmt.Sprintf(cmd.STOR, "quota3.txt")))
+	assertReply(t, c, "552 Requested file action aborted, exceeded storage allocation.\r\n", "test file quota error")
+
+	c.Write([]byte("SITE QUOTA\r\n"))
+	assertReply(t, c, "211 Quota for test: 12 of 16 bytes, 2 of 2 files used.\r\n", "test quota usage error")
+
+	// A file is replaced within the quota, only the difference is charged.
+	stor("quota.txt", "replaced data!", "250 Requested file action okay, completed.\r\n", "test replace error")
+	c.Write([]byte("SITE QUOTA\r\n"))
+	assertReply(t, c, "211 Quota for test: 16 of 16 bytes, 2 of 2 files used.\r\n", "test quota usage error")
+
+	// A file the user did not store gives nothing back when it is replaced.
+	server.SetUserQuota("test", 16, 3)
+	os.WriteFile("quota3.txt", make([]byte, 100), 0666)
+	defer os.Remove("quota3.txt")
+	stor("quota.txt", "ok", "250 Requested file action okay, completed.\r\n", "test replace error")
+	stor("quota3.txt", "ok", "250 Requested file action okay, completed.\r\n", "test replace error")
+	c.Write([]byte("SITE QUOTA\r\n"))
+	assertReply(t, c, "211 Quota for test: 6 of 16 bytes, 3 of 3 files used.\r\n", "test quota usage error")
+}
+
+func Test_AtomicStor(t *testing.T) {
+	os.Mkdir("_atomic_", 0777)
+	defer os.RemoveAll("_atomic_")
+	os.WriteFile("_atomic_/test.txt", []byte("old data"), 0666)
+
+	stalledStor := func(server *_ServerImpl) {
+		t.Helper()
+		server.dataTimeout = 100 * time.Millisecond
+		c := setupServerConn(t, server)
+		defer teardownConn(t, c)
+		login(t, c)
+
+		dataConn := setupDataConn(t, c)
+		defer dataConn.Close()
+		c.Write([]byte(fmt.Sprintf(cmd.STOR, "_atomic_/test.txt")))
+		assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+		dataConn.Write([]byte("new"))
+		assertReply(t, c, "551 Requested file action aborted, file unavailable.\r\n", "test data timeout error")
+	}
+
+	t.Run("remove partial", func(t *testing.T) {
+		stalledStor(NewFtpServer().(*_ServerImpl))
+
+		if data, _ := os.ReadFile("_atomic_/test.txt"); string(data) != "old data" {
+			t.Errorf("failed upload changed the file: %q", data)
+		}
+		if dir, _ := os.ReadDir("_atomic_"); len(dir) != 1 {
+			t.Errorf("failed upload left %d files", len(dir)-1)
+		}
+	})
+
+	t.Run("keep partial", func(t *testing.T) {
+		server := NewFtpServer().(*_ServerImpl)
+		server.SetKeepPartialUploads(true)
+		stalledStor(server)
+
+		if data, _ := os.ReadFile("_atomic_/test.txt"); string(data) != "old data" {
+			t.Errorf("failed upload changed the file: %q", data)
+		}
+		if data, _ := os.ReadFile("_atomic_/.test.txt.part"); string(data) != "new" {
+			t.Errorf("partial upload not kept: %q", data)
+		}
+	})
+}
+
+func Test_Ascii(t *testing.T) {
+	c := setupConn(t)
+	defer teardownConn(t, c)
+	login(t, c)
+	defer os.Remove("ascii.txt")
+
+	c.Write([]byte(fmt.Sprintf(cmd.TYPE, "A")))
+	assertReply(t, c, "200 Command okay.\r\n", "test type error")
+
+	dataConn := setupDataConn(t, c)
+	c.Write([]byte(fmt.Sprintf(cmd.STOR, "ascii.txt")))
+	assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+	dataConn.Write([]byte("line 1\r\nline 2\r\n"))
+	dataConn.Close()
+	assertReply(t, c, "250 Requested file action okay, completed.\r\n", "")
+	if data, _ := os.ReadFile("ascii.txt"); string(data) != "line 1\nline 2\n" {
+		t.Errorf("CRLF not converted: %q", data)
+	}
+
+	c.Write([]byte("SIZE ascii.txt\r\n"))
+	assertReply(t, c, "213 16\r\n", "test ascii size error")
+
+	dataConn = setupDataConn(t, c)
+	c.Write([]byte(fmt.Sprintf(cmd.RETR, "ascii.txt")))
+	assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+	data, _ := io.ReadAll(dataConn)
+	assertReply(t, c, "250 Requested file action okay, completed.\r\n", "")
+	if string(data) != "line 1\r\nline 2\r\n" {
+		t.Errorf("LF not converted: %q", data)
+	}
+
+	c.Write([]byte(fmt.Sprintf(cmd.TYPE, "I")))
+	assertReply(t, c, "200 Command okay.\r\n", "test type error")
+	c.Write([]byte("SIZE ascii.txt\r\n"))
+	assertReply(t, c, "213 14\r\n", "test binary size error")
+}
+
+func Test_Type(t *testing.T) {
+	c := setupConn(t)
+	defer teardownConn(t, c)
+
+	cases := []struct {
+		param, reply string
+	}{
+		{"A", "200 Command okay.\r\n"},
+		{"E T", "200 Command okay.\r\n"},
+		{"A N", "200 Command okay.\r\n"},
+		{"L 8", "200 Command okay.\r\n"},
+		{"A C", "504 Command not implemented for that parameter.\r\n"},
+		{"L 36", "504 Command not implemented for that parameter.\r\n"},
+		{"A X", "501 Syntax error in parameters or arguments.\r\n"},
+		{"L", "501 Syntax error in parameters or arguments.\r\n"},
+		{"", "501 Syntax error in parameters or arguments.\r\n"},
+		{"I", "200 Command okay.\r\n"},
+	}
+	for _, tc := range cases {
+		c.Write([]byte(fmt.Sprintf(cmd.TYPE, tc.param)))
+		assertReply(t, c, tc.reply, "test type "+tc.param+" error")
+	}
+}
+
+func Test_Ebcdic(t *testing.T) {
+	c := setupConn(t)
+	defer teardownConn(t, c)
+	login(t, c)
+	defer os.Remove("ebcdic.txt")
+
+	c.Write([]byte(fmt.Sprintf(cmd.TYPE, "E")))
+	assertReply(t, c, "200 Command okay.\r\n", "test type error")
+
+	dataConn := setupDataConn(t, c)
+	c.Write([]byte(fmt.Sprintf(cmd.STOR, "ebcdic.txt")))
+	assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+	dataConn.Write([]byte{0xc8, 0xc9, 0x15}) // "HI" NL
+	dataConn.Close()
+	assertReply(t, c, "250 Requested file action okay, completed.\r\n", "")
+	if data, _ := os.ReadFile("ebcdic.txt"); string(data) != "HI\n" {
+		t.Errorf("EBCDIC not converted: %q", data)
+	}
+}
+
+func Test_CompressedMode(t *testing.T) {
+	c := setupConn(t)
+	defer teardownConn(t, c)
+	login(t, c)
+	defer os.Remove("compressed.txt")
+
+	c.Write([]byte(fmt.Sprintf(cmd.MODE, 'C')))
+	assertReply(t, c, "200 Command okay.\r\n", "test mode error")
+	c.Write([]byte(fmt.Sprintf(cmd.TYPE, "I")))
+	assertReply(t, c, "200 Command okay.\r\n", "test type error")
+
+	// The data connection is kept for both transfers.
+	dataConn := setupDataConn(t, c)
+	defer dataConn.Close()
+
+	c.Write([]byte(fmt.Sprintf(cmd.STOR, "compressed.txt")))
+	assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+	dataConn.Write([]byte{0x02, 'a', 'b', 0x84, 'c', 0xc2, 0x00, 0x40})
+	assertReply(t, c, "250 Requested file action okay, completed.\r\n", "")
+	if data, _ := os.ReadFile("compressed.txt"); string(data) != "abcccc\x00\x00" {
+		t.Errorf("data not match: %q", data)
+	}
+
+	c.Write([]byte(fmt.Sprintf(cmd.RETR, "compressed.txt")))
+	assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+	var received bytes.Buffer
+	if err := compressed.Receive(&received, dataConn, 0); err != nil {
+		t.Fatal(err)
+	}
+	assertReply(t, c, "250 Requested file action okay, completed.\r\n", "")
+	if received.String() != "abcccc\x00\x00" {
+		t.Errorf("data not match: %q", received.String())
+	}
+}
+
+func Test_Feat(t *testing.T) {
+	c := setupConn(t)
+	defer teardownConn(t, c)
+
+	c.Write([]byte("FEAT\r\n"))
+	assertReply(t, c, "211-Extensions supported:\r\n EPSV\r\n SIZE\r\n MODE Z\r\n MODE B CHECKSUM\r\n MODE B HASH FNV;CRC32C;XXH64;SHA-256\r\n RANG STREAM\r\n REST STREAM\r\n XBLK\r\n XCRC\r\n XMD5\r\n XSHA256\r\n HASH SHA-1;SHA-256*;SHA-512;MD5;CRC32\r\n211 End.\r\n", "test feat error")
+
+	c.Write([]byte("OPTS MODE Z LEVEL 9\r\n"))
+	assertReply(t, c, "200 Command okay.\r\n", "test opts error")
+	c.Write([]byte("OPTS MODE Z LEVEL 10\r\n"))
+	assertReply(t, c, "501 Syntax error in parameters or arguments.\r\n", "test opts error")
+	c.Write([]byte("OPTS UNKNOWN\r\n"))
+	assertReply(t, c, "504 Command not implemented for that parameter.\r\n", "test opts error")
+}
+
+func Test_DeflateMode(t *testing.T) {
+	c := setupConn(t)
+	defer teardownConn(t, c)
+	login(t, c)
+	defer os.Remove("deflate.txt")
+
+	c.Write([]byte(fmt.Sprintf(cmd.MODE, 'Z')))
+	assertReply(t, c, "200 Command okay.\r\n", "test mode error")
+	c.Write([]byte(fmt.Sprintf(cmd.TYPE, "I")))
+	assertReply(t, c, "200 Command okay.\r\n", "test type error")
+
+	data := bytes.Repeat([]byte("log line\n"), 1000)
+
+	dataConn := setupDataConn(t, c)
+	c.Write([]byte(fmt.Sprintf(cmd.STOR, "deflate.txt")))
+	assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+	w := zlib.NewWriter(dataConn)
+	w.Write(data)
+	w.Close()
+	dataConn.Close()
+	assertReply(t, c, "250 Requested file action okay, completed.\r\n", "")
+	if stored, _ := os.ReadFile("deflate.txt"); !bytes.Equal(stored, data) {
+		t.Error("stored data not match")
+	}
+
+	dataConn = setupDataConn(t, c)
+	c.Write([]byte(fmt.Sprintf(cmd.RETR, "deflate.txt")))
+	assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+	var compressed bytes.Buffer
+	io.Copy(&compressed, dataConn)
+	assertReply(t, c, "250 Requested file action okay, completed.\r\n", "")
+	if compressed.Len() >= len(data)/10 {
+		t.Errorf("%d bytes compressed to %d", len(data), compressed.Len())
+	}
+	r, _ := zlib.NewReader(&compressed)
+	if retrieved, _ := io.ReadAll(r); !bytes.Equal(retrieved, data) {
+		t.Error("retrieved data not match")
+	}
+}
+
+// Compares retrieving log files in MODE Z with MODE B, reporting the bytes
+// sent on the data connection per file.
+// A server log of n lines, with times, addresses, users, files, sizes and
+// durations varying as in a real one. The seed is fixed so that every run
+// compresses the same data.
+func benchmarkLog(n int) []byte {
+	r := rand.New(rand.NewSource(1))
+	levels := []string{"INFO", "INFO", "INFO", "INFO", "WARN", "ERROR"}
+	users := []string{"alice", "bob", "carol", "dave", "anonymous"}
+	events := []string{
+		"accepted connection from %s:%d",
+		"%s logged in from %s:%d",
+		"%s stored %s (%d bytes) in %dms",
+		"%s retrieved %s (%d bytes) in %dms",
+		"%s login failed from %s:%d",
+		"%s: read tcp %s:%d: i/o timeout",
+	}
+	files := []string{"photos/IMG_%04d.jpg", "logs/app-%d.log", "backup/db-%d.tar.gz", "docs/report-%d.pdf"}
+
+	var log bytes.Buffer
+	t := time.Date(2021, 11, 20, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		t = t.Add(time.Duration(r.Intn(5000)) * time.Millisecond)
+		ip := fmt.Sprintf("%d.%d.%d.%d", 10+r.Intn(200), r.Intn(256), r.Intn(256), 1+r.Intn(254))
+		port := 1024 + r.Intn(60000)
+		user := users[r.Intn(len(users))]
+		file := fmt.Sprintf(files[r.Intn(len(files))], r.Intn(10000))
+
+		var msg string
+		switch event := r.Intn(len(events)); event {
+		case 0:
+			msg = fmt.Sprintf(events[event], ip, port)
+		case 2, 3:
+			msg = fmt.Sprintf(events[event], user, file, r.Intn(50<<20), r.Intn(30000))
+		default:
+			msg = fmt.Sprintf(events[event], user, ip, port)
+		}
+		fmt.Fprintf(&log, "%s %-5s [session %d] %s\n", t.Format("2006-01-02 15:04:05.000"), levels[r.Intn(len(levels))], r.Intn(1000), msg)
+	}
+	return log.Bytes()
+}
+
+func BenchmarkRetrieveMode(b *testing.B) {
+	data := benchmarkLog(1000)
+
+	retrieve := func(b *testing.B, mode byte) {
+		var sent int64
+		for i := 0; i < b.N; i++ {
+			client, server := net.Pipe()
+			c := &clientHandler{conn: server, mode: mode, type_: TypeBinary, deflateLevel: zlib.DefaultCompression}
+			done := make(chan int64)
+			go func() {
+				n, _ := io.Copy(io.Discard, client)
+				done <- n
+			}()
+
+			switch mode {
+			case ModeBlock:
+				c.retrieveBlockMode(bytes.NewReader(data))
+				server.Close()
+			case ModeDeflate:
+				c.retrieveDeflateMode(bytes.NewReader(data))
+			}
+			sent += <-done
+		}
+		b.ReportMetric(float64(sent)/float64(b.N), "wire-bytes/op")
+	}
+
+	b.Run("block", func(b *testing.B) { retrieve(b, ModeBlock) })
+	b.Run("deflate", func(b *testing.B) { retrieve(b, ModeDeflate) })
+}
+
+func Test_RecordStructure(t *testing.T) {
+	c := setupConn(t)
+	defer teardownConn(t, c)
+	login(t, c)
+	defer os.Remove("record.txt")
+
+	c.Write([]byte("STRU\r\n"))
+	assertReply(t, c, "501 Syntax error in parameters or arguments.\r\n", "test stru error")
+	c.Write([]byte(fmt.Sprintf(cmd.STRU, 'P')))
+	assertReply(t, c, "504 Command not implemented for that parameter.\r\n", "test stru error")
+	c.Write([]byte(fmt.Sprintf(cmd.STRU, 'R')))
+	assertReply(t, c, "200 Command okay.\r\n", "test stru error")
+
+	// Each record is stored as a line.
+	dataConn := setupDataConn(t, c)
+	c.Write([]byte(fmt.Sprintf(cmd.STOR, "record.txt")))
+	assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+	dataConn.Write([]byte("rec 1\xff\x01\xff\x01\xff\xffrec 3\xff\x03"))
+	dataConn.Close()
+	assertReply(t, c, "250 Requested file action okay, completed.\r\n", "")
+	if data, _ := os.ReadFile("record.txt"); string(data) != "rec 1\n\n\xffrec 3\n" {
+		t.Errorf("records not stored as lines: %q", data)
+	}
+
+	dataConn = setupDataConn(t, c)
+	c.Write([]byte(fmt.Sprintf(cmd.RETR, "record.txt")))
+	assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+	data, _ := io.ReadAll(dataConn)
+	assertReply(t, c, "250 Requested file action okay, completed.\r\n", "")
+	if string(data) != "rec 1\xff\x01\xff\x01\xff\xffrec 3\xff\x01\xff\x02" {
+		t.Errorf("lines not sent as records: %q", data)
+	}
+
+	// In block mode the end of record is a descriptor.
+	c.Write([]byte(fmt.Sprintf(cmd.MODE, 'B')))
+	assertReply(t, c, "200 Command okay.\r\n", "test mode error")
+	dataConn = setupDataConn(t, c)
+	defer dataConn.Close()
+	c.Write([]byte(fmt.Sprintf(cmd.RETR, "record.txt")))
+	assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+	var received records
+	if err := block.ReceiveRecords(&received, dataConn); err != nil {
+		t.Fatal(err)
+	}
+	assertReply(t, c, "250 Requested file action okay, completed.\r\n", "")
+	if fmt.Sprintf("%q", received) != `["rec 1" "" "\xffrec 3"]` {
+		t.Errorf("got %q", received)
+	}
+}
+
+type records [][]byte
+
+func (r *records) WriteRecord(record []byte) error {
+	*r = append(*r, record)
+	return nil
+}
+
+func Test_BlockFraming(t *testing.T) {
+	c := setupConn(t)
+	defer teardownConn(t, c)
+	login(t, c)
+	defer os.Remove("block.txt")
+
+	c.Write([]byte("OPTS MODE B FRAMING UNKNOWN\r\n"))
+	assertReply(t, c, "501 Syntax error in parameters or arguments.\r\n", "test opts error")
+
+	c.Write([]byte(fmt.Sprintf(cmd.MODE, 'B')))
+	assertReply(t, c, "200 Command okay.\r\n", "test mode error")
+	c.Write([]byte(fmt.Sprintf(cmd.TYPE, "I")))
+	assertReply(t, c, "200 Command okay.\r\n", "test type error")
+
+	// RFC 959 framing by default.
+	dataConn := setupDataConn(t, c)
+	defer dataConn.Close()
+	c.Write([]byte(fmt.Sprintf(cmd.STOR, "block.txt")))
+	assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+	dataConn.Write([]byte{0x00, 0, 3, 'a', 'b', 'c', block.DescriptorEOF, 0, 1, 'd'})
+	assertReply(t, c, "250 Requested file action okay, completed.\r\n", "")
+	if data, _ := os.ReadFile("block.txt"); string(data) != "abcd" {
+		t.Errorf("got %q", data)
+	}
+
+	c.Write([]byte("OPTS MODE B FRAMING CHECKSUM\r\n"))
+	assertReply(t, c, "200 Command okay.\r\n", "test opts error")
+
+	config := block.Config{Checksum: true}
+	c.Write([]byte(fmt.Sprintf(cmd.STOR, "block.txt")))
+	assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+	config.Send(dataConn, bytes.NewBufferString("checksummed"))
+	assertReply(t, c, "250 Requested file action okay, completed.\r\n", "")
+
+	c.Write([]byte(fmt.Sprintf(cmd.RETR, "block.txt")))
+	assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+	var data bytes.Buffer
+	if err := config.Receive(&data, dataConn); err != nil {
+		t.Fatal(err)
+	}
+	assertReply(t, c, "250 Requested file action okay, completed.\r\n", "")
+	if data.String() != "checksummed" {
+		t.Errorf("got %q", data.String())
+	}
+
+	c.Write([]byte("OPTS MODE B HASH MD4\r\n"))
+	assertReply(t, c, "504 Command not implemented for that parameter.\r\n", "test opts error")
+	c.Write([]byte("OPTS MODE B HASH sha-256\r\n"))
+	assertReply(t, c, "200 Command okay.\r\n", "test opts error")
+	c.Write([]byte("OPTS MODE B BLOCKSIZE 0\r\n"))
+	assertReply(t, c, "501 Syntax error in parameters or arguments.\r\n", "test opts error")
+	c.Write([]byte("OPTS MODE B BLOCKSIZE 4\r\n"))
+	assertReply(t, c, "200 Command okay.\r\n", "test opts error")
+
+	// The header is the block size and the algorithm.
+	c.Write([]byte(fmt.Sprintf(cmd.RETR, "block.txt")))
+	assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+	hdr := make([]byte, 9)
+	io.ReadFull(dataConn, hdr)
+	if !bytes.Equal(hdr, []byte{0, 0, 0, 0, 0, 0, 0, 4, byte(block.HashSHA256)}) {
+		t.Errorf("got header % x", hdr)
+	}
+	data.Reset()
+	if err := config.Receive(&data, io.MultiReader(bytes.NewReader(hdr), dataConn)); err != nil {
+		t.Fatal(err)
+	}
+	assertReply(t, c, "250 Requested file action okay, completed.\r\n", "")
+	if data.String() != "checksummed" {
+		t.Errorf("got %q", data.String())
+	}
+}
+
+func Test_Restart(t *testing.T) {
+	os.Mkdir("_restart_", 0777)
+	defer os.RemoveAll("_restart_")
+	os.WriteFile("_restart_/file.bin", []byte("0123456789"), 0666)
+
+	server := NewFtpServer().(*_ServerImpl)
+	server.SetKeepPartialUploads(true)
+	server.SetRestartInterval(4)
+	c := setupServerConn(t, server)
+	defer teardownConn(t, c)
+	login(t, c)
+
+	c.Write([]byte(fmt.Sprintf(cmd.TYPE, "I")))
+	assertReply(t, c, "200 Command okay.\r\n", "test type error")
+	c.Write([]byte("REST x\r\n"))
+	assertReply(t, c, "501 Syntax error in parameters or arguments.\r\n", "test rest error")
+
+	// Stream mode
+	dataConn := setupDataConn(t, c)
+	c.Write([]byte("REST 4\r\n"))
+	assertReply(t, c, "350 Requested file action pending further information.\r\n", "test rest error")
+	c.Write([]byte(fmt.Sprintf(cmd.RETR, "_restart_/file.bin")))
+	assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+	data, _ := io.ReadAll(dataConn)
+	assertReply(t, c, "250 Requested file action okay, completed.\r\n", "")
+	if string(data) != "456789" {
+		t.Errorf("got %q", data)
+	}
+
+	// Block mode sends restart markers.
+	c.Write([]byte(fmt.Sprintf(cmd.MODE, 'B')))
+	assertReply(t, c, "200 Command okay.\r\n", "test mode error")
+	dataConn = setupDataConn(t, c)
+	c.Write([]byte(fmt.Sprintf(cmd.RETR, "_restart_/file.bin")))
+	assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+	var markers []string
+	var file bytes.Buffer
+	if err := (block.Config{OnRestart: func(m string) { markers = append(markers, m) }}).Receive(&file, dataConn); err != nil {
+		t.Fatal(err)
+	}
+	assertReply(t, c, "250 Requested file action okay, completed.\r\n", "")
+	if file.String() != "0123456789" || fmt.Sprint(markers) != "[4 8]" {
+		t.Errorf("got %q, markers %v", file.String(), markers)
+	}
+
+	// An upload broken after the marker 8 is resumed there.
+	var sent bytes.Buffer
+	block.Config{RestartInterval: 4}.Send(&sent, bytes.NewBufferString("abcdefghij"))
+	c.Write([]byte(fmt.Sprintf(cmd.STOR, "_restart_/upload.bin")))
+	assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+	dataConn.Write(sent.Bytes()[:sent.Len()-1])
+	dataConn.Close()
+	assertReply(t, c, "110 MARK 4 = 4\r\n", "test restart marker error")
+	assertReply(t, c, "110 MARK 8 = 8\r\n", "test restart marker error")
+	assertReply(t, c, "551 Requested file action aborted, file unavailable.\r\n", "")
+
+	c.Write([]byte("REST 12\r\n"))
+	assertReply(t, c, "350 Requested file action pending further information.\r\n", "test rest error")
+	dataConn = setupDataConn(t, c)
+	defer dataConn.Close()
+	c.Write([]byte(fmt.Sprintf(cmd.STOR, "_restart_/upload.bin")))
+	assertReply(t, c, "554 Requested action not taken, invalid REST parameter.\r\n", "test rest error")
+
+	c.Write([]byte("REST 8\r\n"))
+	assertReply(t, c, "350 Requested file action pending further information.\r\n", "test rest error")
+	c.Write([]byte(fmt.Sprintf(cmd.STOR, "_restart_/upload.bin")))
+	assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+	block.Config{RestartInterval: 4, RestartOffset: 8}.Send(dataConn, bytes.NewBufferString("ij"))
+	assertReply(t, c, "250 Requested file action okay, completed.\r\n", "")
+	if data, _ := os.ReadFile("_restart_/upload.bin"); string(data) != "abcdefghij" {
+		t.Errorf("got %q", data)
+	}
+}
+
+func Test_Range(t *testing.T) {
+	os.Mkdir("_range_", 0777)
+	defer os.RemoveAll("_range_")
+	os.WriteFile("_range_/file.bin", []byte("0123456789"), 0666)
+
+	c := setupConn(t)
+	defer teardownConn(t, c)
+	login(t, c)
+
+	c.Write([]byte(fmt.Sprintf(cmd.TYPE, "I")))
+	assertReply(t, c, "200 Command okay.\r\n", "test type error")
+	c.Write([]byte("RANG 5 2\r\n"))
+	assertReply(t, c, "501 Syntax error in parameters or arguments.\r\n", "test rang error")
+
+	dataConn := setupDataConn(t, c)
+	c.Write([]byte("RANG 2 5\r\n"))
+	assertReply(t, c, "350 Restarting at 2. Ending byte range at 5.\r\n", "test rang error")
+	c.Write([]byte(fmt.Sprintf(cmd.RETR, "_range_/file.bin")))
+	assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+	data, _ := io.ReadAll(dataConn)
+	assertReply(t, c, "250 Requested file action okay, completed.\r\n", "")
+	if string(data) != "2345" {
+		t.Errorf("got %q", data)
+	}
+
+	// The range applies to one transfer only, or is reset by "RANG 1 0".
+	dataConn = setupDataConn(t, c)
+	c.Write([]byte("RANG 2 5\r\n"))
+	assertReply(t, c, "350 Restarting at 2. Ending byte range at 5.\r\n", "test rang error")
+	c.Write([]byte("RANG 1 0\r\n"))
+	assertReply(t, c, "350 Byte range reset.\r\n", "test rang error")
+	c.Write([]byte(fmt.Sprintf(cmd.RETR, "_range_/file.bin")))
+	assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+	data, _ = io.ReadAll(dataConn)
+	assertReply(t, c, "250 Requested file action okay, completed.\r\n", "")
+	if string(data) != "0123456789" {
+		t.Errorf("got %q", data)
+	}
+
+	// A range can not be stored.
+	dataConn = setupDataConn(t, c)
+	defer dataConn.Close()
+	c.Write([]byte("RANG 0 3\r\n"))
+	assertReply(t, c, "350 Restarting at 0. Ending byte range at 3.\r\n", "test rang error")
+	c.Write([]byte(fmt.Sprintf(cmd.STOR, "_range_/upload.bin")))
+	assertReply(t, c, "504 Command not implemented for that parameter.\r\n", "test rang error")
+}
+
+func Test_Repair(t *testing.T) {
+	os.Mkdir("_repair_", 0777)
+	defer os.RemoveAll("_repair_")
+	original := bytes.Repeat([]byte("0123456789"), 300)
+	os.WriteFile("_repair_/file.bin", original, 0666)
+
+	c := setupConn(t)
+	defer teardownConn(t, c)
+	login(t, c)
+
+	for _, line := range []string{"TYPE I", "MODE B", "OPTS MODE B FRAMING CHECKSUM", "OPTS MODE B REPAIR ON"} {
+		c.Write([]byte(line + "\r\n"))
+		assertReply(t, c, "200 Command okay.\r\n", line)
+	}
+	c.Write([]byte("XBLK 0\r\n"))
+	assertReply(t, c, "425 Can't open data connection.\r\n", "test xblk error")
+	dataConn := setupDataConn(t, c)
+	defer dataConn.Close()
+	c.Write([]byte("XBLK 0\r\n"))
+	assertReply(t, c, "503 Bad sequence of commands.\r\n", "test xblk error")
+
+	// The blocks 0 and 2 of the upload are damaged.
+	config := block.Config{Checksum: true, Repair: true}
+	var sent bytes.Buffer
+	config.Send(&sent, bytes.NewReader(original))
+	data := sent.Bytes()
+	blockSize := 1 << 10
+	data[9] ^= 1
+	data[9+2*(blockSize+17)] ^= 1
+
+	c.Write([]byte(fmt.Sprintf(cmd.STOR, "_repair_/upload.bin")))
+	assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+	dataConn.Write(data)
+	assertReply(t, c, "350-Damaged blocks, resend them by XBLK:\r\n 0\r\n 2\r\n350 Requested file action pending further information.\r\n", "test damaged error")
+	if _, err := os.Stat("_repair_/upload.bin"); err == nil {
+		t.Fatal("upload should wait for the repair")
+	}
+
+	c.Write([]byte("XBLK 1\r\n"))
+	assertReply(t, c, "501 Syntax error in parameters or arguments.\r\n", "test xblk error")
+	c.Write([]byte("XBLK 2\r\n"))
+	assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+	config.Resend(dataConn, bytes.NewReader(original), 2)
+	assertReply(t, c, "350-Damaged blocks, resend them by XBLK:\r\n 0\r\n350 Requested file action pending further information.\r\n", "test damaged error")
+	c.Write([]byte("XBLK 0\r\n"))
+	assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+	config.Resend(dataConn, bytes.NewReader(original), 0)
+	assertReply(t, c, "250 Requested file action okay, completed.\r\n", "")
+	if stored, _ := os.ReadFile("_repair_/upload.bin"); !bytes.Equal(stored, original) {
+		t.Fatal("stored file not repaired")
+	}
+
+	// A block of the last RETR is resent.
+	c.Write([]byte(fmt.Sprintf(cmd.RETR, "_repair_/file.bin")))
+	assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+	config.Receive(io.Discard, dataConn)
+	assertReply(t, c, "250 Requested file action okay, completed.\r\n", "")
+	c.Write([]byte("XBLK 1\r\n"))
+	assertReply(t, c, "125 Data connection already open; transfer starting.\r\n", "")
+	repaired := make(fileAt, len(original))
+	if err := config.ReceiveResent(repaired, 1, dataConn); err != nil {
+		t.Fatal(err)
+	}
+	assertReply(t, c, "250 Requested file action okay, completed.\r\n", "")
+	if !bytes.Equal(repaired[blockSize:2*blockSize], original[blockSize:2*blockSize]) {
+		t.Fatal("block not resent")
+	}
+}
+
+type fileAt []byte
+
+func (f fileAt) WriteAt(p []byte, off int64) (int, error) {
+	return copy(f[off:], p), nil
+}
+
+func Test_Hash(t *testing.T) {
+	os.Mkdir("_hash_", 0777)
+	defer os.RemoveAll("_hash_")
+	os.WriteFile("_hash_/file.txt", []byte("line1\nline2\n"), 0666)
+
+	c := setupConn(t)
+	defer teardownConn(t, c)
+
+	// Hashed as transferred in the ASCII type.
+	sum := sha256.Sum256([]byte("line1\r\nline2\r\n"))
+	c.Write([]byte("HASH _hash_/file.txt\r\n"))
+	assertReply(t, c, fmt.Sprintf("213 SHA-256 0-13 %x _hash_/file.txt\r\n", sum), "test hash error")
+
+	c.Write([]byte(fmt.Sprintf(cmd.TYPE, "I")))
+	assertReply(t, c, "200 Command okay.\r\n", "test type error")
+	c.Write([]byte("OPTS HASH SHA-3\r\n"))
+	assertReply(t, c, "504 Command not implemented for that parameter.\r\n", "test opts error")
+	c.Write([]byte("OPTS HASH md5\r\n"))
+	assertReply(t, c, "200 MD5\r\n", "test opts error")
+	c.Write([]byte("OPTS HASH\r\n"))
+	assertReply(t, c, "200 MD5\r\n", "test opts error")
+	c.Write([]byte("FEAT\r\n"))
+	if readReply(c); !strings.Contains(string(__buffer[:__n]), " HASH SHA-1;SHA-256;SHA-512;MD5*;CRC32\r\n") {
+		t.Errorf("got %q", __buffer[:__n])
+	}
+	c.Write([]byte("HASH _hash_/file.txt\r\n"))
+	assertReply(t, c, fmt.Sprintf("213 MD5 0-11 %x _hash_/file.txt\r\n", md5.Sum([]byte("line1\nline2\n"))), "test hash error")
+
+	c.Write([]byte("RANG 6 10\r\n"))
+	assertReply(t, c, "350 Restarting at 6. Ending byte range at 10.\r\n", "test rang error")
+	c.Write([]byte("HASH _hash_/file.txt\r\n"))
+	assertReply(t, c, fmt.Sprintf("213 MD5 6-10 %x _hash_/file.txt\r\n", md5.Sum([]byte("line2"))), "test hash error")
+
+	c.Write([]byte("XCRC _hash_/file.txt\r\n"))
+	assertReply(t, c, fmt.Sprintf("250 %08x\r\n", crc32.ChecksumIEEE([]byte("line1\nline2\n"))), "test xcrc error")
+	c.Write([]byte("XMD5 _hash_/file.txt\r\n"))
+	assertReply(t, c, fmt.Sprintf("250 %x\r\n", md5.Sum([]byte("line1\nline2\n"))), "test xmd5 error")
+	c.Write([]byte("XSHA256 _hash_/file.txt\r\n"))
+	assertReply(t, c, fmt.Sprintf("250 %x\r\n", sha256.Sum256([]byte("line1\nline2\n"))), "test xsha256 error")
+
+	c.Write([]byte("HASH _hash_/none\r\n"))
+	assertReply(t, c, "550 File unavailable.\r\n", "test hash error")
+	c.Write([]byte("HASH _hash_\r\n"))
+	assertReply(t, c, "550 File unavailable.\r\n", "test hash error")
+	c.Write([]byte("XCRC\r\n"))
+	assertReply(t, c, "501 Syntax error in parameters or arguments.\r\n", "test xcrc error")
+}
+
+func Test_Pipeline(t *testing.T) {
+	os.Mkdir("_pipeline_", 0777)
+	defer os.RemoveAll("_pipeline_")
+	os.WriteFile("_pipeline_/file.bin", []byte("file"), 0666)
+
+	c := setupConn(t)
+	defer teardownConn(t, c)
+	login(t, c)
+
+	for _, line := range []string{"TYPE I", "MODE B", "OPTS MODE B PIPELINE ON"} {
+		c.Write([]byte(line + "\r\n"))
+		assertReply(t, c, "200 Command okay.\r\n", line)
+	}
+	dataConn := setupDataConn(t, c)
+	defer dataConn.Close()
+
+	// The second file can not be created, it is dropped to keep the data
+	// connection in step.
+	c.Write([]byte("STOR _pipeline_/a.bin\r\nSTOR _pipeline_/file.bin/b.bin\r\nSTOR _pipeline_/c.bin\r\n"))
+	for _, data := range []string{"a", "b", "c"} {
+		block.Send(dataConn, bytes.NewBufferString(data))
+	}
+	for _, reply := range []string{
+		"125 Data connection already open; transfer starting.\r\n",
+		"250 Requested file action okay, completed.\r\n",
+		"550 File unavailable.\r\n",
+		"125 Data connection already open; transfer starting.\r\n",
+		"250 Requested file action okay, completed.\r\n",
+	} {
+		assertReply(t, c, reply, "test pipeline error")
+	}
+	if data, _ := os.ReadFile("_pipeline_/c.bin"); string(data) != "c" {
+		t.Errorf("got %q", data)
+	}
+
+	c.Write([]byte(fmt.Sprintf(cmd.RETR, "_pipeline_/a.bin") + fmt.Sprintf(cmd.RETR, "_pipeline_/none") + fmt.Sprintf(cmd.RETR, "_pipeline_/c.bin")))
+	for _, reply := range []string{
+		"125 Data connection already open; transfer starting.\r\n",
+		"250 Requested file action okay, completed.\r\n",
+		"550 File unavailable.\r\n",
+		"125 Data connection already open; transfer starting.\r\n",
+		"250 Requested file action okay, completed.\r\n",
+	} {
+		assertReply(t, c, reply, "test pipeline error")
+	}
+	for _, expect := range []string{"a", "c"} {
+		var data bytes.Buffer
+		if err := block.Receive(&data, dataConn); err != nil || data.String() != expect {
+			t.Errorf("got %q, %v", data.String(), err)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package server
+
+import "strings"
+
+var _ commandHandler = (*clientHandler).handleSITE
+
+type siteHandler func(c *clientHandler, param string) error
+
+// SITE<SP><string><CRLF>, the first word of the string selects the handler.
+var siteHandlers = map[string]siteHandler{
+	"QUOTA": (*clientHandler).handleSiteQUOTA,
+}
+
+func (c *clientHandler) handleSITE(param string) error {
+	part := strings.SplitN(param, " ", 2)
+	if handler, has := siteHandlers[strings.ToUpper(part[0])]; has {
+		if len(part) != 2 {
+			return handler(c, "")
+		}
+		return handler(c, part[1])
+	}
+	return c.reply(StatusCommandNotImplementedForParameter)
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net"
+	"time"
+)
+
+var (
+	_ commandHandler = (*clientHandler).handleNOOP
+)
+
+// NOOP<CRLF>, which only resets the idle timeout like any command.
+func (c *clientHandler) handleNOOP(param string) error {
+	return c.reply(StatusOK)
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+// The control connection is closed if the client sends no command within the
+// idle timeout, or does not log in within the login timeout.
+func (c *clientHandler) readDeadline() (deadline time.Time) {
+	if c.idleTimeout > 0 {
+		deadline = time.Now().Add(c.idleTimeout)
+	}
+	if !c.login && !c.loginDeadline.IsZero() &&
+		(deadline.IsZero() || c.loginDeadline.Before(deadline)) {
+		deadline = c.loginDeadline
+	}
+	return
+}
+
+func (c *clientHandler) timeoutReason() string {
+	if !c.login && !c.loginDeadline.IsZero() && !time.Now().Before(c.loginDeadline) {
+		return "login timeout"
+	}
+	return "idle timeout"
+}
+
+// A data connection which fails any Read or Write that makes no progress
+// within the timeout, so that a stalled peer cannot block the session forever.
+type timeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (conn *timeoutConn) Read(b []byte) (int, error) {
+	conn.Conn.SetReadDeadline(time.Now().Add(conn.timeout))
+	return conn.Conn.Read(b)
+}
+
+func (conn *timeoutConn) Write(b []byte) (int, error) {
+	conn.Conn.SetWriteDeadline(time.Now().Add(conn.timeout))
+	return conn.Conn.Write(b)
+}